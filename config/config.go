@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -19,23 +21,45 @@ var Version = "0.0.0"
 
 // Config is the configuration structure
 type Config struct {
-	Workers                 int                              `json:"workers"`
-	Interval                string                           `json:"interval"`
-	Logfile                 string                           `json:"logfile"`
-	Loglevel                string                           `json:"loglevel"`
-	Helper                  string                           `json:"helper"`
-	Token                   string                           `json:"token"`
-	LifecycleComponent      string                           `json:"lifecycle_component"`
-	Insecure                bool                             `json:"choria_insecure"`
-	Site                    string                           `json:"site"`
-	MonitorPort             int                              `json:"monitor_port"`
-	BrokerPort              int                              `json:"broker_port"`
-	BrokerProvisionPassword string                           `json:"broker_provisioning_password"`
-	BrokerChoriaPassword    string                           `json:"broker_choria_password"`
-	Management              *backplane.StandardConfiguration `json:"management" yaml:"management"`
-	CertDenyList            []string                         `json:"cert_deny_list"`
-	JWTVerifyCert           string                           `json:"jwt_verify_cert"`
-	RegoPolicy              string                           `json:"rego_policy"`
+	Workers                  int                              `json:"workers"`
+	Interval                 string                           `json:"interval"`
+	Logfile                  string                           `json:"logfile"`
+	Loglevel                 string                           `json:"loglevel"`
+	Helper                   string                           `json:"helper"`
+	Token                    string                           `json:"token"`
+	LifecycleComponent       string                           `json:"lifecycle_component"`
+	Insecure                 bool                             `json:"choria_insecure"`
+	Site                     string                           `json:"site"`
+	MonitorPort              int                              `json:"monitor_port"`
+	BrokerPort               int                              `json:"broker_port"`
+	BrokerProvisionPassword  string                           `json:"broker_provisioning_password"`
+	BrokerChoriaPassword     string                           `json:"broker_choria_password"`
+	Management               *backplane.StandardConfiguration `json:"management" yaml:"management"`
+	CertDenyList             []string                         `json:"cert_deny_list"`
+	JWTVerifyCert            string                           `json:"jwt_verify_cert"`
+	RegoPolicy               string                           `json:"rego_policy"`
+	ReplyGrace               string                           `json:"rpc_reply_grace"`
+	ProvisionTimeout         string                           `json:"provision_timeout"`
+	WebhookURL               string                           `json:"webhook_url"`
+	WebhookTimeout           string                           `json:"webhook_timeout"`
+	WebhookRetries           int                              `json:"webhook_retries"`
+	SecretPatterns           []string                         `json:"secret_patterns"`
+	MaxConcurrentRestarts    int                              `json:"max_concurrent_restarts"`
+	RestartWindow            string                           `json:"restart_window"`
+	MaxAttempts              int                              `json:"max_attempts"`
+	RestartVerifyTimeout     string                           `json:"restart_verify_timeout"`
+	CACert                   string                           `json:"ca_cert"`
+	CAKey                    string                           `json:"ca_key"`
+	CAValidity               string                           `json:"ca_validity"`
+	RequireFacts             bool                             `json:"require_facts"`
+	MaxReprovisions          int                              `json:"max_reprovisions"`
+	ReprovisionWindow        string                           `json:"reprovision_window"`
+	RetryableAborts          []string                         `json:"retryable_aborts"`
+	AbortRetries             int                              `json:"abort_retries"`
+	RequireCertificate       bool                             `json:"require_certificate"`
+	SiteDefaults             map[string]*SiteDefaults         `json:"site_defaults"`
+	JWTRefreshWindow         string                           `json:"jwt_refresh_window"`
+	AcceptDuplicateResponses bool                             `json:"accept_duplicate_responses"`
 
 	Features struct {
 		PKI    bool `json:"pki"`
@@ -43,8 +67,17 @@ type Config struct {
 		Broker bool `json:"broker"`
 	} `json:"features"`
 
-	IntervalDuration time.Duration `json:"-"`
-	File             string        `json:"-"`
+	IntervalDuration             time.Duration `json:"-"`
+	ReplyGraceDuration           time.Duration `json:"-"`
+	ProvisionTimeoutDuration     time.Duration `json:"-"`
+	WebhookTimeoutDuration       time.Duration `json:"-"`
+	RestartWindowDuration        time.Duration `json:"-"`
+	RestartVerifyTimeoutDuration time.Duration `json:"-"`
+	CAValidityDuration           time.Duration `json:"-"`
+	ReprovisionWindowDuration    time.Duration `json:"-"`
+	JWTRefreshWindowDuration     time.Duration `json:"-"`
+	QuarantineCooldownDuration   time.Duration `json:"-"`
+	File                         string        `json:"-"`
 
 	paused bool
 	sync.Mutex
@@ -93,6 +126,44 @@ func Load(file string) (*Config, error) {
 		}
 	}
 
+	if config.MaxReprovisions > 0 {
+		config.ReprovisionWindowDuration = time.Minute
+		if config.ReprovisionWindow != "" {
+			config.ReprovisionWindowDuration, err = time.ParseDuration(config.ReprovisionWindow)
+			if err != nil {
+				return nil, fmt.Errorf("invalid reprovision window: %s", err)
+			}
+		}
+	}
+
+	if config.RestartVerifyTimeout != "" {
+		config.RestartVerifyTimeoutDuration, err = time.ParseDuration(config.RestartVerifyTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid restart verify timeout: %s", err)
+		}
+	}
+
+	if config.CACert != "" {
+		if config.CAKey == "" {
+			return nil, fmt.Errorf("ca_key is required when ca_cert is set")
+		}
+
+		config.CAValidityDuration = 365 * 24 * time.Hour
+		if config.CAValidity != "" {
+			config.CAValidityDuration, err = time.ParseDuration(config.CAValidity)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ca validity: %s", err)
+			}
+		}
+	}
+
+	for _, pattern := range config.SecretPatterns {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %s: %s", pattern, err)
+		}
+	}
+
 	config.IntervalDuration, err = time.ParseDuration(config.Interval)
 	if err != nil {
 		return nil, fmt.Errorf("invalid duration: %s", err)
@@ -102,6 +173,78 @@ func Load(file string) (*Config, error) {
 		return nil, errors.New("interval is too small, minmum is 1 minute.  Valid example values are 10m or 10h")
 	}
 
+	if config.ReplyGrace != "" {
+		config.ReplyGraceDuration, err = time.ParseDuration(config.ReplyGrace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rpc reply grace: %s", err)
+		}
+	}
+
+	if config.ProvisionTimeout != "" {
+		config.ProvisionTimeoutDuration, err = time.ParseDuration(config.ProvisionTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provision timeout: %s", err)
+		}
+	}
+
+	if config.WebhookURL != "" {
+		u, err := url.Parse(config.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid webhook url: %s", config.WebhookURL)
+		}
+
+		config.WebhookTimeoutDuration = 10 * time.Second
+		if config.WebhookTimeout != "" {
+			config.WebhookTimeoutDuration, err = time.ParseDuration(config.WebhookTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid webhook timeout: %s", err)
+			}
+		}
+
+		if config.WebhookRetries <= 0 {
+			config.WebhookRetries = 3
+		}
+	}
+
+	if config.MaxConcurrentRestarts > 0 {
+		config.RestartWindowDuration = time.Minute
+		if config.RestartWindow != "" {
+			config.RestartWindowDuration, err = time.ParseDuration(config.RestartWindow)
+			if err != nil {
+				return nil, fmt.Errorf("invalid restart window: %s", err)
+			}
+		}
+	}
+
+	if config.JWTRefreshWindow != "" {
+		config.JWTRefreshWindowDuration, err = time.ParseDuration(config.JWTRefreshWindow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT refresh window: %s", err)
+		}
+	}
+
+	if config.QuarantineCooldown != "" {
+		config.QuarantineCooldownDuration, err = time.ParseDuration(config.QuarantineCooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quarantine cooldown: %s", err)
+		}
+	}
+
+	for site, defaults := range config.SiteDefaults {
+		if defaults == nil {
+			continue
+		}
+
+		err = defaults.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid site defaults for %s: %s", site, err)
+		}
+	}
+
+	if len(config.RetryableAborts) > 0 && config.AbortRetries <= 0 {
+		config.AbortRetries = 3
+	}
+
 	pausedGauge.WithLabelValues(config.Site).Set(0)
 
 	return config, nil
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config")
+}
+
+var _ = Describe("Config", func() {
+	var c *Config
+
+	BeforeEach(func() {
+		c = &Config{}
+	})
+
+	Describe("IsSecret", func() {
+		It("Should match the default patterns", func() {
+			for _, key := range []string{"token", "Token", "broker_provisioning_password", "client_secret", "plugin.choria.security.seed"} {
+				Expect(c.IsSecret(key)).To(BeTrue(), key)
+			}
+
+			for _, key := range []string{"site", "plugin.choria.srv_domain", "seed"} {
+				Expect(c.IsSecret(key)).To(BeFalse(), key)
+			}
+		})
+
+		It("Should support custom patterns", func() {
+			c.SecretPatterns = []string{"*.key", "site"}
+
+			Expect(c.IsSecret("plugin.example.key")).To(BeTrue())
+			Expect(c.IsSecret("site")).To(BeTrue())
+			Expect(c.IsSecret("token")).To(BeFalse())
+		})
+	})
+
+	Describe("Redact", func() {
+		It("Should redact nested secrets", func() {
+			c.SecretPatterns = []string{"*password*"}
+
+			data := map[string]interface{}{
+				"site":     "ginkgo",
+				"password": "s3cret",
+				"management": map[string]interface{}{
+					"name":            "provisioner",
+					"broker_password": "s3cret",
+				},
+			}
+
+			Expect(c.Redact(data)).To(Equal(map[string]interface{}{
+				"site":     "ginkgo",
+				"password": Redacted,
+				"management": map[string]interface{}{
+					"name":            "provisioner",
+					"broker_password": Redacted,
+				},
+			}))
+			Expect(data["password"]).To(Equal("s3cret"))
+		})
+
+		It("Should redact string maps", func() {
+			Expect(c.RedactStrings(map[string]string{"plugin.choria.provision.token": "x", "identity": "y"})).To(Equal(map[string]string{
+				"plugin.choria.provision.token": Redacted,
+				"identity":                      "y",
+			}))
+		})
+	})
+
+	Describe("Defaults", func() {
+		It("Should default the restart splay", func() {
+			c.Site = "ginkgo"
+			Expect(c.Defaults()).To(Equal(SiteDefaults{RestartSplay: 1}))
+		})
+
+		It("Should use the defaults for the configured site", func() {
+			c.Site = "ginkgo"
+			c.SiteDefaults = map[string]*SiteDefaults{
+				"ginkgo": {CSROrganization: "Acme", RestartSplay: 10},
+				"other":  {CSROrganization: "Other"},
+			}
+
+			Expect(c.Defaults()).To(Equal(SiteDefaults{CSROrganization: "Acme", RestartSplay: 10}))
+		})
+	})
+
+	Describe("SiteDefaults", func() {
+		It("Should validate and parse the request timeout", func() {
+			d := &SiteDefaults{RequestTimeout: "30s"}
+			Expect(d.Validate()).To(Succeed())
+			Expect(d.RequestTimeoutDuration).To(Equal(30 * time.Second))
+
+			d = &SiteDefaults{RequestTimeout: "soon"}
+			Expect(d.Validate()).To(MatchError(`invalid request timeout: time: invalid duration "soon"`))
+
+			d = &SiteDefaults{RestartSplay: -1}
+			Expect(d.Validate()).To(MatchError("invalid restart splay -1"))
+		})
+
+		It("Should let set overrides win", func() {
+			d := SiteDefaults{CSROrganization: "Acme", CSRCountry: "DE", RestartSplay: 10}
+			o := SiteDefaults{CSRCountry: "MT", RequestTimeout: "5s", RequestTimeoutDuration: 5 * time.Second}
+
+			Expect(d.Merge(o)).To(Equal(SiteDefaults{
+				CSROrganization:        "Acme",
+				CSRCountry:             "MT",
+				RestartSplay:           10,
+				RequestTimeout:         "5s",
+				RequestTimeoutDuration: 5 * time.Second,
+			}))
+		})
+	})
+
+	Describe("FactData", func() {
+		It("Should not expose secrets", func() {
+			c.Token = "toomanysecrets"
+			c.BrokerChoriaPassword = "s3cret"
+			c.Site = "ginkgo"
+
+			data := c.FactData().(map[string]interface{})
+			Expect(data["token"]).To(Equal(Redacted))
+			Expect(data["broker_choria_password"]).To(Equal(Redacted))
+			Expect(data["site"]).To(Equal("ginkgo"))
+		})
+	})
+})
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SiteDefaults are request parameters used for all hosts of a site unless overridden per host
+type SiteDefaults struct {
+	CSROrganization       string `json:"csr_organization"`
+	CSROrganizationalUnit string `json:"csr_organizational_unit"`
+	CSRCountry            string `json:"csr_country"`
+	CSRProvince           string `json:"csr_province"`
+	CSRLocality           string `json:"csr_locality"`
+	RestartSplay          int    `json:"restart_splay"`
+	RequestTimeout        string `json:"request_timeout"`
+
+	RequestTimeoutDuration time.Duration `json:"-"`
+}
+
+// Validate checks the defaults and parses the request timeout
+func (d *SiteDefaults) Validate() (err error) {
+	if d.RestartSplay < 0 {
+		return fmt.Errorf("invalid restart splay %d", d.RestartSplay)
+	}
+
+	if d.RequestTimeout != "" {
+		d.RequestTimeoutDuration, err = time.ParseDuration(d.RequestTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid request timeout: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Merge creates new defaults where all set values in o override those in d
+func (d SiteDefaults) Merge(o SiteDefaults) SiteDefaults {
+	if o.CSROrganization != "" {
+		d.CSROrganization = o.CSROrganization
+	}
+
+	if o.CSROrganizationalUnit != "" {
+		d.CSROrganizationalUnit = o.CSROrganizationalUnit
+	}
+
+	if o.CSRCountry != "" {
+		d.CSRCountry = o.CSRCountry
+	}
+
+	if o.CSRProvince != "" {
+		d.CSRProvince = o.CSRProvince
+	}
+
+	if o.CSRLocality != "" {
+		d.CSRLocality = o.CSRLocality
+	}
+
+	if o.RestartSplay > 0 {
+		d.RestartSplay = o.RestartSplay
+	}
+
+	if o.RequestTimeoutDuration > 0 {
+		d.RequestTimeout = o.RequestTimeout
+		d.RequestTimeoutDuration = o.RequestTimeoutDuration
+	}
+
+	return d
+}
+
+// Defaults are the request defaults for the site this provisioner serves
+func (c *Config) Defaults() SiteDefaults {
+	d := SiteDefaults{RestartSplay: 1}
+
+	if sd, ok := c.SiteDefaults[c.Site]; ok && sd != nil {
+		d = d.Merge(*sd)
+	}
+
+	return d
+}
@@ -1,8 +1,23 @@
 package config
 
+import (
+	"encoding/json"
+)
+
 // FactData implements backplane.InfoSource
 func (c *Config) FactData() interface{} {
-	return c
+	j, err := json.Marshal(c)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+
+	data := map[string]interface{}{}
+	err = json.Unmarshal(j, &data)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+
+	return c.Redact(data)
 }
 
 // Version implements backplane.InfoSource
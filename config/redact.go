@@ -0,0 +1,65 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultSecretPatterns are the key patterns treated as secret when secret_patterns is not configured
+var DefaultSecretPatterns = []string{"*token*", "*password*", "*secret*", "*.seed"}
+
+// Redacted is the value used in place of redacted secrets
+const Redacted = "[REDACTED]"
+
+// IsSecret determines if a key matches any of the secret patterns, matching is case insensitive
+func (c *Config) IsSecret(key string) bool {
+	patterns := c.SecretPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultSecretPatterns
+	}
+
+	key = strings.ToLower(key)
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), key); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact returns a copy of data with the values of all secret keys replaced, nested maps are redacted recursively
+func (c *Config) Redact(data map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(data))
+
+	for k, v := range data {
+		switch {
+		case c.IsSecret(k):
+			result[k] = Redacted
+		default:
+			if nested, ok := v.(map[string]interface{}); ok {
+				result[k] = c.Redact(nested)
+			} else {
+				result[k] = v
+			}
+		}
+	}
+
+	return result
+}
+
+// RedactStrings returns a copy of data with the values of all secret keys replaced
+func (c *Config) RedactStrings(data map[string]string) map[string]string {
+	result := make(map[string]string, len(data))
+
+	for k, v := range data {
+		if c.IsSecret(k) {
+			result[k] = Redacted
+		} else {
+			result[k] = v
+		}
+	}
+
+	return result
+}
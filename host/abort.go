@@ -0,0 +1,67 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	rpc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
+)
+
+// AbortError is returned when the node aborted a request, Message is the reason given by the node
+type AbortError struct {
+	Identity string
+	Action   string
+	Message  string
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("%s aborted %s: %s", e.Identity, e.Action, e.Message)
+}
+
+// abortRetryInterval is the initial delay before retrying a retryable abort, it doubles on every retry
+var abortRetryInterval = time.Second
+
+// isRetryableAbort determines if err is an abort matching one of the retryable_aborts patterns
+func (h *Host) isRetryableAbort(err error) bool {
+	var abort *AbortError
+	if !errors.As(err, &abort) {
+		return false
+	}
+
+	return matchAnyRegex(abort.Message, h.cfg.RetryableAborts)
+}
+
+// retryAborts wraps r so that requests aborted by the node for a retryable reason are attempted again with backoff
+func (h *Host) retryAborts(r Requester) Requester {
+	if len(h.cfg.RetryableAborts) == 0 {
+		return r
+	}
+
+	return RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) (err error) {
+		interval := abortRetryInterval
+
+		for try := 1; try <= h.cfg.AbortRetries; try++ {
+			if try > 1 {
+				abortRetryCtr.WithLabelValues(h.cfg.Site, fmt.Sprintf("%s#%s", agent, action)).Inc()
+				h.log.Warnf("Retrying %s#%s after try %d / %d: %s", agent, action, try-1, h.cfg.AbortRetries, err)
+
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				interval *= 2
+			}
+
+			err = r.Request(ctx, agent, action, input, handler)
+			if !h.isRetryableAbort(err) {
+				return err
+			}
+		}
+
+		return err
+	})
+}
@@ -0,0 +1,91 @@
+package host
+
+import (
+	"sync"
+)
+
+// AttemptTracker tracks failed provisioning attempts per host and dead letters hosts that failed too often
+type AttemptTracker struct {
+	max        int
+	site       string
+	attempts   map[string]int
+	deadLetter map[string]string
+	mu         *sync.Mutex
+}
+
+// NewAttemptTracker creates a tracker that dead letters hosts after max failed attempts, 0 means never
+func NewAttemptTracker(max int, site string) *AttemptTracker {
+	return &AttemptTracker{
+		max:        max,
+		site:       site,
+		attempts:   make(map[string]int),
+		deadLetter: make(map[string]string),
+		mu:         &sync.Mutex{},
+	}
+}
+
+// Failed records a failed attempt and reports if the host is now dead lettered
+func (t *AttemptTracker) Failed(identity string, err error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.attempts[identity]++
+
+	if t.max > 0 && t.attempts[identity] >= t.max {
+		t.deadLetter[identity] = err.Error()
+		delete(t.attempts, identity)
+		deadLetterCtr.WithLabelValues(t.site).Inc()
+
+		return true
+	}
+
+	return false
+}
+
+// Succeeded clears the attempt history of a host
+func (t *AttemptTracker) Succeeded(identity string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.attempts, identity)
+}
+
+// Attempts is the number of failed attempts recorded for a host that is not dead lettered
+func (t *AttemptTracker) Attempts(identity string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.attempts[identity]
+}
+
+// DeadLettered reports if a host is dead lettered and the last error it failed with
+func (t *AttemptTracker) DeadLettered(identity string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reason, ok := t.deadLetter[identity]
+
+	return reason, ok
+}
+
+// DeadLetters are all dead lettered hosts and the last error they failed with
+func (t *AttemptTracker) DeadLetters() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[string]string, len(t.deadLetter))
+	for k, v := range t.deadLetter {
+		result[k] = v
+	}
+
+	return result
+}
+
+// Clear removes a host from the dead letter list and resets its attempts
+func (t *AttemptTracker) Clear(identity string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.deadLetter, identity)
+	delete(t.attempts, identity)
+}
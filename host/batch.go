@@ -0,0 +1,199 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/provisioning-agent/config"
+)
+
+const (
+	// StatusSucceeded indicates a host was provisioned
+	StatusSucceeded = "succeeded"
+
+	// StatusFailed indicates a host failed to provision and will be attempted again
+	StatusFailed = "failed"
+
+	// StatusDeadLettered indicates a host failed too often and will not be attempted again
+	StatusDeadLettered = "dead_lettered"
+
+	// StatusQuarantined indicates a host failed verification too often and is skipped until released
+	StatusQuarantined = "quarantined"
+)
+
+// HostResult is the outcome of provisioning a single host in a batch
+type HostResult struct {
+	Identity string    `json:"identity"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"`
+}
+
+// BatchCounts summarizes the outcomes of a batch
+type BatchCounts struct {
+	Total        int `json:"total"`
+	Succeeded    int `json:"succeeded"`
+	Failed       int `json:"failed"`
+	DeadLettered int `json:"dead_lettered"`
+	Quarantined  int `json:"quarantined"`
+}
+
+// BatchResult is the outcome of provisioning a batch of hosts
+type BatchResult struct {
+	Site        string             `json:"site"`
+	Provisioner string             `json:"provisioner"`
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Counts      BatchCounts        `json:"counts"`
+	Hosts       []*HostResult      `json:"hosts"`
+	Quarantined []*QuarantinedHost `json:"quarantined"`
+}
+
+// BatchProvisioner provisions batches of hosts concurrently, hosts that repeatedly fail across
+// batches are dead lettered and hosts repeatedly failing verification are quarantined, both are skipped in later batches
+type BatchProvisioner struct {
+	Attempts   *AttemptTracker
+	Quarantine *Quarantine
+
+	fw  *choria.Framework
+	cfg *config.Config
+}
+
+// provisionFunc provisions a single host
+var provisionFunc = func(ctx context.Context, h *Host, fw *choria.Framework) error {
+	return h.Provision(ctx, fw)
+}
+
+// NewBatchProvisioner creates a batch provisioner using the configured workers and max attempts
+func NewBatchProvisioner(fw *choria.Framework, conf *config.Config) *BatchProvisioner {
+	return &BatchProvisioner{
+		Attempts:   NewAttemptTracker(conf.MaxAttempts, conf.Site),
+		Quarantine: NewQuarantine(conf.QuarantineAfter, conf.QuarantineCooldownDuration, conf.Site),
+		fw:         fw,
+		cfg:        conf,
+	}
+}
+
+// ProvisionBatch provisions hosts using up to the configured number of concurrent workers
+func (b *BatchProvisioner) ProvisionBatch(ctx context.Context, hosts []*Host) *BatchResult {
+	result := &BatchResult{
+		Site:  b.cfg.Site,
+		Start: time.Now(),
+		Hosts: []*HostResult{},
+	}
+
+	if b.fw != nil {
+		result.Provisioner = b.fw.Config.Identity
+	}
+
+	workers := b.cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	rmu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	work := make(chan *Host, len(hosts))
+
+	for _, h := range hosts {
+		if reason, dead := b.Attempts.DeadLettered(h.Identity); dead {
+			now := time.Now()
+			result.add(&HostResult{Identity: h.Identity, Status: StatusDeadLettered, Error: reason, Start: now, End: now})
+			continue
+		}
+
+		if qh, quarantined := b.Quarantine.Quarantined(h.Identity); quarantined {
+			now := time.Now()
+			result.add(&HostResult{Identity: h.Identity, Status: StatusQuarantined, Error: qh.Reason, Start: now, End: now})
+			continue
+		}
+
+		work <- h
+	}
+	close(work)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for h := range work {
+				hr := &HostResult{Identity: h.Identity, Start: time.Now()}
+				err := provisionFunc(ctx, h, b.fw)
+				hr.End = time.Now()
+
+				switch {
+				case err == nil:
+					b.Attempts.Succeeded(h.Identity)
+					b.Quarantine.Succeeded(h.Identity)
+					hr.Status = StatusSucceeded
+
+				case b.Attempts.Failed(h.Identity, err):
+					hr.Status = StatusDeadLettered
+					hr.Error = err.Error()
+
+				case b.Quarantine.Failed(h.Identity, err):
+					hr.Status = StatusQuarantined
+					hr.Error = err.Error()
+
+				default:
+					hr.Status = StatusFailed
+					hr.Error = err.Error()
+				}
+
+				rmu.Lock()
+				result.add(hr)
+				rmu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	sort.Slice(result.Hosts, func(i, j int) bool { return result.Hosts[i].Identity < result.Hosts[j].Identity })
+	result.Quarantined = b.Quarantine.Hosts()
+	result.End = time.Now()
+
+	return result
+}
+
+func (r *BatchResult) add(hr *HostResult) {
+	hr.Duration = hr.End.Sub(hr.Start).Seconds()
+	r.Hosts = append(r.Hosts, hr)
+	r.Counts.Total++
+
+	switch hr.Status {
+	case StatusSucceeded:
+		r.Counts.Succeeded++
+	case StatusFailed:
+		r.Counts.Failed++
+	case StatusDeadLettered:
+		r.Counts.DeadLettered++
+	case StatusQuarantined:
+		r.Counts.Quarantined++
+	}
+}
+
+// WithStatus are the results of hosts with a specific status
+func (r *BatchResult) WithStatus(status string) []*HostResult {
+	found := []*HostResult{}
+
+	for _, hr := range r.Hosts {
+		if hr.Status == status {
+			found = append(found, hr)
+		}
+	}
+
+	return found
+}
+
+// JSON encodes the result as JSON
+func (r *BatchResult) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
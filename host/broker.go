@@ -0,0 +1,68 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/srvcache"
+)
+
+// SetBrokers directs RPC requests for this host to specific brokers rather than the ones
+// configured in the Choria framework, brokers are given in host:port format
+func (h *Host) SetBrokers(brokers []string) error {
+	for _, broker := range brokers {
+		err := validateBroker(broker)
+		if err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.brokers = brokers
+
+	return nil
+}
+
+// Brokers are the brokers this host is being provisioned through, empty when using the framework defaults
+func (h *Host) Brokers() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.brokers
+}
+
+func (h *Host) connect(ctx context.Context) (choria.Connector, error) {
+	servers := func() (srvcache.Servers, error) {
+		return srvcache.StringHostsToServers(h.brokers, "nats")
+	}
+
+	conn, err := h.framework().NewConnector(ctx, servers, fmt.Sprintf("provisioner %s", h.Identity), h.log)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to brokers %v: %s", h.brokers, err)
+	}
+
+	return conn, nil
+}
+
+func validateBroker(broker string) error {
+	host, port, err := net.SplitHostPort(broker)
+	if err != nil {
+		return fmt.Errorf("invalid broker %s: %s", broker, err)
+	}
+
+	if host == "" {
+		return fmt.Errorf("invalid broker %s: no host given", broker)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid broker %s: invalid port %s", broker, port)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package host
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// ProvisionResult describes the outcome of provisioning a host
+type ProvisionResult struct {
+	Identity        string
+	Metadata        string
+	CertFingerprint string
+	Error           error
+}
+
+// ProvisionCallback is called after a host was provisioned, or failed to provision
+type ProvisionCallback func(result ProvisionResult)
+
+// callbackTimeout is how long the pipeline waits for a callback to complete
+var callbackTimeout = 10 * time.Second
+
+// OnSuccess sets a callback to invoke after the host was successfully configured and restarted
+func (h *Host) OnSuccess(cb ProvisionCallback) {
+	h.onSuccess = cb
+}
+
+// OnFailure sets a callback to invoke when provisioning the host failed
+func (h *Host) OnFailure(cb ProvisionCallback) {
+	h.onFailure = cb
+}
+
+func (h *Host) notify(err error) {
+	cb := h.onSuccess
+	if err != nil {
+		cb = h.onFailure
+	}
+
+	if cb == nil {
+		return
+	}
+
+	result := h.result(err)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		cb(result)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(callbackTimeout):
+		h.log.Warnf("Provisioning callback for %s did not complete within %s, continuing", h.Identity, callbackTimeout)
+	}
+}
+
+func (h *Host) result(err error) ProvisionResult {
+	result := ProvisionResult{
+		Identity: h.Identity,
+		Metadata: h.Metadata,
+		Error:    err,
+	}
+
+	if h.cert != "" {
+		fp, ferr := certFingerprint(h.cert)
+		if ferr != nil {
+			h.log.Warnf("Could not calculate certificate fingerprint: %s", ferr)
+		}
+		result.CertFingerprint = fp
+	}
+
+	return result
+}
+
+func certFingerprint(cert string) (string, error) {
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil {
+		return "", fmt.Errorf("could not decode certificate PEM")
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(block.Bytes)), nil
+}
@@ -0,0 +1,23 @@
+package host
+
+import (
+	"github.com/choria-io/go-choria/choria"
+)
+
+// SetCaller performs RPC requests for this host using a different Choria framework, the
+// caller identity and security context of that framework is what the node sees in its audit log
+func (h *Host) SetCaller(fw *choria.Framework) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.caller = fw
+}
+
+// framework is the Choria framework used to perform RPC requests
+func (h *Host) framework() *choria.Framework {
+	if h.caller != nil {
+		return h.caller
+	}
+
+	return h.fw
+}
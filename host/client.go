@@ -0,0 +1,169 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+	rpc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
+	addl "github.com/choria-io/go-choria/providers/agent/mcorpc/ddl/agent"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc/golang/provision"
+)
+
+// Requester performs a RPC request against a single node, handler is called with a successful reply from that node
+type Requester interface {
+	Request(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error
+}
+
+// RequesterFunc adapts a function to the Requester interface
+type RequesterFunc func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error
+
+// Request implements Requester
+func (f RequesterFunc) Request(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+	return f(ctx, agent, action, input, handler)
+}
+
+// ProvisionClient performs typed choria_provision requests against a single node
+type ProvisionClient struct {
+	token     string
+	requester Requester
+}
+
+// NewProvisionClient creates a client that authenticates requests using token and performs them using requester
+func NewProvisionClient(token string, requester Requester) *ProvisionClient {
+	return &ProvisionClient{
+		token:     token,
+		requester: requester,
+	}
+}
+
+// GenCSR requests the node to create a private key and a CSR
+func (c *ProvisionClient) GenCSR(ctx context.Context, req *provision.CSRRequest) (*provision.CSRReply, error) {
+	req.Token = c.token
+	reply := &provision.CSRReply{}
+
+	return reply, c.request(ctx, "gencsr", req, reply)
+}
+
+// Configure requests the node to write its configuration, certificate and CA
+func (c *ProvisionClient) Configure(ctx context.Context, req *provision.ConfigureRequest) (*provision.Reply, error) {
+	req.Token = c.token
+	reply := &provision.Reply{}
+
+	return reply, c.request(ctx, "configure", req, reply)
+}
+
+// Restart requests the node to restart after a splay
+func (c *ProvisionClient) Restart(ctx context.Context, req *provision.RestartRequest) (*provision.Reply, error) {
+	req.Token = c.token
+	reply := &provision.Reply{}
+
+	return reply, c.request(ctx, "restart", req, reply)
+}
+
+// Reprovision requests the node to leave its collective and enter provisioning mode
+func (c *ProvisionClient) Reprovision(ctx context.Context, req *provision.ReprovisionRequest) (*provision.Reply, error) {
+	req.Token = c.token
+	reply := &provision.Reply{}
+
+	return reply, c.request(ctx, "reprovision", req, reply)
+}
+
+// ReleaseUpdate requests the node to update its Choria binary
+func (c *ProvisionClient) ReleaseUpdate(ctx context.Context, req *provision.ReleaseUpdateRequest) (*provision.Reply, error) {
+	req.Token = c.token
+	reply := &provision.Reply{}
+
+	return reply, c.request(ctx, "release_update", req, reply)
+}
+
+// JWT retrieves the provisioning JWT from the node
+func (c *ProvisionClient) JWT(ctx context.Context, req *provision.JWTRequest) (*provision.JWTReply, error) {
+	req.Token = c.token
+	reply := &provision.JWTReply{}
+
+	return reply, c.request(ctx, "jwt", req, reply)
+}
+
+func (c *ProvisionClient) request(ctx context.Context, action string, input interface{}, output interface{}) error {
+	var received bool
+	var perr error
+
+	err := c.requester.Request(ctx, "choria_provision", action, input, func(pr protocol.Reply, reply *rpc.RPCReply) {
+		received = true
+
+		perr = json.Unmarshal(reply.Data, output)
+		if perr != nil {
+			perr = fmt.Errorf("could not parse reply from %s: %s", pr.SenderID(), perr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if !received {
+		return fmt.Errorf("no successful choria_provision#%s reply received", action)
+	}
+
+	return perr
+}
+
+// NodeRequester performs requests against a single node in the provisioning collective using a Choria Framework
+type NodeRequester struct {
+	fw       *choria.Framework
+	identity string
+}
+
+// NewNodeRequester creates a Requester for the node identity
+func NewNodeRequester(fw *choria.Framework, identity string) *NodeRequester {
+	return &NodeRequester{
+		fw:       fw,
+		identity: identity,
+	}
+}
+
+// Request implements Requester
+func (r *NodeRequester) Request(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+	ddl, err := addl.CachedDDL(agent)
+	if err != nil {
+		return fmt.Errorf("could not find DDL for agent %s in the agent cache", agent)
+	}
+
+	client, err := rpc.New(r.fw, agent, rpc.DDL(ddl))
+	if err != nil {
+		return fmt.Errorf("could not create %s client: %s", agent, err)
+	}
+
+	var failure error
+
+	result, err := client.Do(ctx, action, input, rpc.Targets([]string{r.identity}), rpc.Collective("provisioning"), rpc.Workers(1), rpc.ReplyHandler(func(pr protocol.Reply, reply *rpc.RPCReply) {
+		if pr.SenderID() != r.identity {
+			return
+		}
+
+		if reply.Statuscode == mcorpc.Aborted {
+			failure = &AbortError{Identity: r.identity, Action: fmt.Sprintf("%s#%s", agent, action), Message: reply.Statusmsg}
+			return
+		}
+
+		if reply.Statuscode != mcorpc.OK {
+			failure = fmt.Errorf("failed reply from %s: %s", pr.SenderID(), reply.Statusmsg)
+			return
+		}
+
+		handler(pr, reply)
+	}))
+	if err != nil {
+		return fmt.Errorf("could not perform %s#%s: %s", agent, action, err)
+	}
+
+	err = checkResponseCount(r.identity, result.Stats().ResponsesCount())
+	if err != nil {
+		return fmt.Errorf("could not perform %s#%s: %s", agent, action, err)
+	}
+
+	return failure
+}
@@ -0,0 +1,38 @@
+package host
+
+import (
+	"github.com/choria-io/go-choria/providers/agent/mcorpc/golang/provision"
+	"github.com/choria-io/provisioning-agent/config"
+)
+
+// SetDefaults overrides the site defaults for this host, only values set in d are overridden
+func (h *Host) SetDefaults(d config.SiteDefaults) error {
+	err := d.Validate()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.overrides = d
+	h.mu.Unlock()
+
+	return nil
+}
+
+// defaults are the site defaults with the host overrides applied
+func (h *Host) defaults() config.SiteDefaults {
+	return h.cfg.Defaults().Merge(h.overrides)
+}
+
+func (h *Host) csrRequest() *provision.CSRRequest {
+	d := h.defaults()
+
+	return &provision.CSRRequest{
+		CN: h.Identity,
+		O:  d.CSROrganization,
+		OU: d.CSROrganizationalUnit,
+		C:  d.CSRCountry,
+		ST: d.CSRProvince,
+		L:  d.CSRLocality,
+	}
+}
@@ -32,18 +32,26 @@ type Host struct {
 	CSR         *provision.CSRReply `json:"csr"`
 	Metadata    string              `json:"inventory"`
 	JWT         *provClaims         `json:"jwt"`
+	DryRun      bool                `json:"dry_run,omitempty"`
 	rawJWT      string
 	config      map[string]string
 	provisioned bool
 	ca          string
 	cert        string
+	brokers     []string
+	overrides   config.SiteDefaults
 
 	cfg       *config.Config
 	token     string
 	fw        *choria.Framework
+	caller    *choria.Framework
+	startup   StartupWatcher
+	requester Requester
 	log       *logrus.Entry
 	mu        *sync.Mutex
 	replylock *sync.Mutex
+	onSuccess ProvisionCallback
+	onFailure ProvisionCallback
 }
 
 func NewHost(identity string, conf *config.Config) *Host {
@@ -68,6 +76,14 @@ func (h *Host) Provision(ctx context.Context, fw *choria.Framework) error {
 	h.fw = fw
 	h.log = fw.Logger(h.Identity)
 
+	err := h.withDeadline(ctx, h.provision)
+	h.notify(err)
+	h.postWebhook(ctx, err)
+
+	return err
+}
+
+func (h *Host) provision(ctx context.Context) error {
 	if h.cfg.Features.JWT {
 		err := h.fetchJWT(ctx)
 		if err != nil {
@@ -111,14 +127,26 @@ func (h *Host) Provision(ctx context.Context, fw *choria.Framework) error {
 	h.ca = config.CA
 	h.cert = config.Certificate
 
+	if h.cfg.Features.PKI && h.cfg.CACert != "" && h.cert == "" {
+		err = h.signCSR()
+		if err != nil {
+			return fmt.Errorf("could not provision %s: %s", h.Identity, err)
+		}
+	}
+
+	err = h.validateConfiguration()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %s", err)
+	}
+
 	err = h.configure(ctx)
 	if err != nil {
 		return fmt.Errorf("configuration failed: %s", err)
 	}
 
-	err = h.restart(ctx)
+	err = h.restartAndVerify(ctx)
 	if err != nil {
-		return fmt.Errorf("restart failed: %s", err)
+		return fmt.Errorf("restart failed: %w", err)
 	}
 
 	h.provisioned = true
@@ -126,6 +154,37 @@ func (h *Host) Provision(ctx context.Context, fw *choria.Framework) error {
 	return nil
 }
 
+// withDeadline runs fn bounded by the configured provision timeout, fn is expected to honor ctx
+func (h *Host) withDeadline(ctx context.Context, fn func(context.Context) error) error {
+	if h.cfg.ProvisionTimeoutDuration <= 0 {
+		return fn(ctx)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, h.cfg.ProvisionTimeoutDuration)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- fn(tctx) }()
+
+	select {
+	case err := <-errc:
+		if err != nil && tctx.Err() == context.DeadlineExceeded {
+			timeoutCtr.WithLabelValues(h.cfg.Site).Inc()
+			return fmt.Errorf("provisioning %s did not complete within %s: %s", h.Identity, h.cfg.ProvisionTimeoutDuration, err)
+		}
+
+		return err
+
+	case <-tctx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		timeoutCtr.WithLabelValues(h.cfg.Site).Inc()
+		return fmt.Errorf("provisioning %s did not complete within %s", h.Identity, h.cfg.ProvisionTimeoutDuration)
+	}
+}
+
 func (h *Host) String() string {
 	return h.Identity
 }
@@ -161,6 +220,17 @@ func (h *Host) validateJWT() error {
 	return nil
 }
 
+func (h *Host) signCSR() error {
+	signer, err := NewSigner(h.cfg.CACert, h.cfg.CAKey, h.cfg.CAValidityDuration)
+	if err != nil {
+		return err
+	}
+
+	h.cert, h.ca, err = signer.Sign(h.CSR)
+
+	return err
+}
+
 func (h *Host) validateCSR() error {
 	if h.CSR == nil {
 		return fmt.Errorf("no CSR received")
@@ -196,6 +266,44 @@ func (h *Host) validateCSR() error {
 	return nil
 }
 
+// validateConfiguration checks the configuration from the helper for contradictions the node would only detect after restarting
+func (h *Host) validateConfiguration() error {
+	collectives := []string{"mcollective"}
+
+	if c, ok := h.config["collectives"]; ok {
+		collectives = []string{}
+		seen := make(map[string]bool)
+
+		for _, collective := range strings.Split(c, ",") {
+			collective = strings.TrimSpace(collective)
+			if collective == "" {
+				return fmt.Errorf("collectives %q contains an empty collective", c)
+			}
+
+			if seen[collective] {
+				return fmt.Errorf("collectives %q contains %s more than once", c, collective)
+			}
+
+			seen[collective] = true
+			collectives = append(collectives, collective)
+		}
+	}
+
+	mainCollective, ok := h.config["main_collective"]
+	if !ok {
+		return nil
+	}
+
+	mainCollective = strings.TrimSpace(mainCollective)
+	for _, collective := range collectives {
+		if collective == mainCollective {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("main collective %s is not one of the collectives %s", mainCollective, strings.Join(collectives, ", "))
+}
+
 func matchAnyRegex(str string, regex []string) bool {
 	for _, reg := range regex {
 		if matched, _ := regexp.MatchString("^/.+/$", reg); matched {
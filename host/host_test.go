@@ -1,19 +1,34 @@
 package host
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/sirupsen/logrus"
 
+	"github.com/choria-io/go-choria/choria"
+	rpc "github.com/choria-io/go-choria/providers/agent/mcorpc/client"
 	"github.com/choria-io/go-choria/providers/agent/mcorpc/golang/provision"
 	"github.com/choria-io/provisioning-agent/config"
 
@@ -37,6 +52,7 @@ var _ = Describe("Host", func() {
 			Identity: "ginkgo.example.net",
 			CSR:      &provision.CSRReply{},
 			log:      log,
+			mu:       &sync.Mutex{},
 			cfg: &config.Config{
 				CertDenyList: []string{
 					"\\.privileged.mcollective$",
@@ -86,8 +102,1068 @@ var _ = Describe("Host", func() {
 			Expect(h.validateCSR()).To(BeNil())
 		})
 	})
+
+	Describe("validateInventory", func() {
+		It("Should accept well formed inventories", func() {
+			Expect(h.validateInventory(`{"agents":["choria_provision","rpcutil"],"facts":{"os":"linux"}}`)).To(Succeed())
+			Expect(h.validateInventory(`{"agents":["choria_provision","rpcutil"],"facts":{}}`)).To(Succeed())
+		})
+
+		It("Should reject empty or malformed inventories", func() {
+			Expect(h.validateInventory("")).To(MatchError("empty inventory received from ginkgo.example.net"))
+			Expect(h.validateInventory("{}")).To(MatchError("invalid inventory received from ginkgo.example.net: no agents listed"))
+			Expect(h.validateInventory(`{"agents":["rpcutil"]}`)).To(MatchError("invalid inventory received from ginkgo.example.net: no facts"))
+			Expect(h.validateInventory(`[]`)).To(MatchError(ContainSubstring("invalid inventory received from ginkgo.example.net: json")))
+		})
+
+		It("Should optionally require facts", func() {
+			h.cfg.RequireFacts = true
+			Expect(h.validateInventory(`{"agents":["rpcutil"],"facts":{}}`)).To(MatchError("invalid inventory received from ginkgo.example.net: facts are empty"))
+			Expect(h.validateInventory(`{"agents":["rpcutil"],"facts":{"os":"linux"}}`)).To(Succeed())
+		})
+	})
+
+	Describe("validateConfiguration", func() {
+		It("Should accept consistent collectives", func() {
+			h.config = map[string]string{}
+			Expect(h.validateConfiguration()).To(Succeed())
+
+			h.config = map[string]string{"collectives": "mcollective, production,monitoring", "main_collective": "production"}
+			Expect(h.validateConfiguration()).To(Succeed())
+
+			h.config = map[string]string{"collectives": "production"}
+			Expect(h.validateConfiguration()).To(Succeed())
+
+			h.config = map[string]string{"main_collective": "mcollective"}
+			Expect(h.validateConfiguration()).To(Succeed())
+		})
+
+		It("Should detect a main collective that is not a collective", func() {
+			h.config = map[string]string{"collectives": "mcollective,monitoring", "main_collective": "production"}
+			Expect(h.validateConfiguration()).To(MatchError("main collective production is not one of the collectives mcollective, monitoring"))
+
+			h.config = map[string]string{"main_collective": "production"}
+			Expect(h.validateConfiguration()).To(MatchError("main collective production is not one of the collectives mcollective"))
+		})
+
+		It("Should detect malformed collectives", func() {
+			h.config = map[string]string{"collectives": "mcollective,,production"}
+			Expect(h.validateConfiguration()).To(MatchError(`collectives "mcollective,,production" contains an empty collective`))
+
+			h.config = map[string]string{"collectives": "production,mcollective,production"}
+			Expect(h.validateConfiguration()).To(MatchError(`collectives "production,mcollective,production" contains production more than once`))
+		})
+	})
+
+	Describe("configure", func() {
+		var sent *provision.ConfigureRequest
+
+		BeforeEach(func() {
+			sent = nil
+			h.config = map[string]string{"identity": h.Identity}
+			h.requester = RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+				sent = input.(*provision.ConfigureRequest)
+				handler(nil, &rpc.RPCReply{Data: []byte(`{"message":"configured"}`)})
+				return nil
+			})
+		})
+
+		It("Should refuse to configure without TLS material when required", func() {
+			h.cfg.RequireCertificate = true
+			h.cert = "cert"
+
+			Expect(h.configure(context.Background())).To(MatchError("refusing to configure ginkgo.example.net without CA, ssldir"))
+			Expect(sent).To(BeNil())
+		})
+
+		It("Should configure with TLS material when required", func() {
+			h.cfg.RequireCertificate = true
+			h.cert = "cert"
+			h.ca = "ca"
+			h.CSR.SSLDir = "/etc/choria/ssl"
+
+			Expect(h.configure(context.Background())).To(Succeed())
+			Expect(sent.Certificate).To(Equal("cert"))
+			Expect(sent.SSLDir).To(Equal("/etc/choria/ssl"))
+		})
+
+		It("Should configure without TLS material when not required", func() {
+			Expect(h.configure(context.Background())).To(Succeed())
+			Expect(sent.Certificate).To(BeEmpty())
+			Expect(sent.Configuration).To(Equal(`{"identity":"ginkgo.example.net"}`))
+		})
+	})
+
+	Describe("defaults", func() {
+		var sent interface{}
+
+		BeforeEach(func() {
+			h.cfg.Site = "ginkgo"
+			h.cfg.SiteDefaults = map[string]*config.SiteDefaults{
+				"ginkgo": {CSROrganization: "Acme", CSRCountry: "DE", RestartSplay: 10},
+			}
+
+			h.requester = RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+				sent = input
+				handler(nil, &rpc.RPCReply{Data: []byte(`{"message":"ok"}`)})
+				return nil
+			})
+		})
+
+		It("Should apply the site defaults", func() {
+			Expect(h.fetchCSR(context.Background())).To(Succeed())
+			Expect(sent.(*provision.CSRRequest).CN).To(Equal("ginkgo.example.net"))
+			Expect(sent.(*provision.CSRRequest).O).To(Equal("Acme"))
+			Expect(sent.(*provision.CSRRequest).C).To(Equal("DE"))
+
+			Expect(h.restart(context.Background())).To(Succeed())
+			Expect(sent.(*provision.RestartRequest).Splay).To(Equal(10))
+		})
+
+		It("Should let host overrides win", func() {
+			Expect(h.SetDefaults(config.SiteDefaults{CSRCountry: "MT", RestartSplay: 2})).To(Succeed())
+
+			Expect(h.fetchCSR(context.Background())).To(Succeed())
+			Expect(sent.(*provision.CSRRequest).O).To(Equal("Acme"))
+			Expect(sent.(*provision.CSRRequest).C).To(Equal("MT"))
+
+			Expect(h.restart(context.Background())).To(Succeed())
+			Expect(sent.(*provision.RestartRequest).Splay).To(Equal(2))
+		})
+
+		It("Should reject invalid overrides", func() {
+			Expect(h.SetDefaults(config.SiteDefaults{RequestTimeout: "soon"})).To(HaveOccurred())
+		})
+	})
+
+	Describe("JWT", func() {
+		var td string
+		var key []byte
+
+		BeforeEach(func() {
+			var err error
+			var cert []byte
+
+			td, err = ioutil.TempDir("", "")
+			Expect(err).ToNot(HaveOccurred())
+
+			cert, key, err = genca()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(td, "jwt.pem"), cert, 0600)).To(Succeed())
+
+			h.cfg.JWTVerifyCert = filepath.Join(td, "jwt.pem")
+			h.cfg.JWTRefreshWindow = "1h"
+			h.cfg.JWTRefreshWindowDuration = time.Hour
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(td)
+		})
+
+		It("Should detect tokens needing a refresh", func() {
+			Expect(h.jwtNeedsRefresh()).To(BeTrue())
+
+			for _, tc := range []struct {
+				expires time.Duration
+				refresh bool
+			}{
+				{24 * time.Hour, false},
+				{30 * time.Minute, true},
+				{-time.Minute, true},
+				{0, false},
+			} {
+				token, err := genjwt(key, tc.expires)
+				Expect(err).ToNot(HaveOccurred())
+
+				h.rawJWT = token
+				Expect(h.jwtNeedsRefresh()).To(Equal(tc.refresh), tc.expires.String())
+			}
+		})
+
+		It("Should report the token status", func() {
+			_, err := h.JWTStatus()
+			Expect(err).To(MatchError("no validated JWT for ginkgo.example.net"))
+
+			h.rawJWT, err = genjwt(key, 2*time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h.validateJWT()).To(Succeed())
+
+			status, err := h.JWTStatus()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Issuer).To(Equal("ginkgo"))
+			Expect(status.Subject).To(Equal("ginkgo.example.net"))
+			Expect(status.Remaining).To(BeNumerically("~", 2*time.Hour, time.Minute))
+		})
+
+		It("Should retrieve tokens again within the refresh window", func() {
+			fresh, err := genjwt(key, 24*time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+
+			calls := 0
+			h.requester = RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+				calls++
+				handler(nil, &rpc.RPCReply{Data: []byte(fmt.Sprintf(`{"jwt":%q}`, fresh))})
+				return nil
+			})
+
+			h.rawJWT, err = genjwt(key, 10*time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h.fetchJWT(context.Background())).To(Succeed())
+			Expect(calls).To(Equal(1))
+			Expect(h.rawJWT).To(Equal(fresh))
+
+			Expect(h.fetchJWT(context.Background())).To(Succeed())
+			Expect(calls).To(Equal(1))
+		})
+
+		It("Should fail validation of expired tokens", func() {
+			var err error
+			h.rawJWT, err = genjwt(key, -time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h.validateJWT()).To(MatchError(ContainSubstring("expired")))
+		})
+	})
+
+	Describe("SetBrokers", func() {
+		It("Should validate the brokers", func() {
+			Expect(h.SetBrokers([]string{"broker1.example.net"})).To(MatchError(ContainSubstring("invalid broker broker1.example.net")))
+			Expect(h.SetBrokers([]string{":4222"})).To(MatchError("invalid broker :4222: no host given"))
+			Expect(h.SetBrokers([]string{"broker1.example.net:x"})).To(MatchError("invalid broker broker1.example.net:x: invalid port x"))
+			Expect(h.Brokers()).To(BeEmpty())
+		})
+
+		It("Should target distinct brokers per host", func() {
+			other := NewHost("other.example.net", h.cfg)
+
+			Expect(h.SetBrokers([]string{"site1-broker1.example.net:4222", "site1-broker2.example.net:4222"})).To(Succeed())
+			Expect(other.SetBrokers([]string{"site2-broker1.example.net:4222"})).To(Succeed())
+
+			Expect(h.Brokers()).To(Equal([]string{"site1-broker1.example.net:4222", "site1-broker2.example.net:4222"}))
+			Expect(other.Brokers()).To(Equal([]string{"site2-broker1.example.net:4222"}))
+		})
+	})
+
+	Describe("pacer", func() {
+		It("Should limit operations within the window", func() {
+			p := newPacer(2, 100*time.Millisecond)
+
+			var started []time.Duration
+			start := time.Now()
+			throttled := 0
+			for i := 0; i < 5; i++ {
+				t, err := p.wait(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				if t {
+					throttled++
+				}
+				started = append(started, time.Since(start))
+			}
+
+			Expect(throttled).To(Equal(3))
+
+			Expect(started[1]).To(BeNumerically("<", 50*time.Millisecond))
+			Expect(started[2]).To(BeNumerically(">=", 100*time.Millisecond))
+			Expect(started[3]).To(BeNumerically(">=", 100*time.Millisecond))
+			Expect(started[4]).To(BeNumerically(">=", 200*time.Millisecond))
+		})
+
+		It("Should honor the context while waiting", func() {
+			p := newPacer(1, time.Hour)
+			_, err := p.wait(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, err = p.wait(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+
+	Describe("Reprovision", func() {
+		AfterEach(func() {
+			reprovisionPacer = nil
+		})
+
+		It("Should pace reprovisions under a burst", func() {
+			h.cfg.MaxReprovisions = 2
+			h.cfg.ReprovisionWindowDuration = 100 * time.Millisecond
+
+			rmu := sync.Mutex{}
+			var sent []time.Duration
+			start := time.Now()
+
+			requester := RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+				Expect(action).To(Equal("reprovision"))
+				Expect(input.(*provision.ReprovisionRequest).Splay).To(Equal(10))
+
+				rmu.Lock()
+				sent = append(sent, time.Since(start))
+				rmu.Unlock()
+
+				handler(nil, &rpc.RPCReply{Data: []byte(`{"message":"reprovisioning"}`)})
+
+				return nil
+			})
+
+			wg := sync.WaitGroup{}
+			for i := 0; i < 4; i++ {
+				other := NewHost(fmt.Sprintf("node%d.example.net", i), h.cfg)
+				other.log = h.log
+				other.requester = requester
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+
+					Expect(other.Reprovision(context.Background(), nil, 10)).To(Succeed())
+				}()
+			}
+			wg.Wait()
+
+			sort.Slice(sent, func(i, j int) bool { return sent[i] < sent[j] })
+			Expect(sent).To(HaveLen(4))
+			Expect(sent[1]).To(BeNumerically("<", 50*time.Millisecond))
+			Expect(sent[2]).To(BeNumerically(">=", 100*time.Millisecond))
+			Expect(sent[3]).To(BeNumerically(">=", 100*time.Millisecond))
+		})
+	})
+
+	Describe("retryAborts", func() {
+		var calls int
+
+		aborting := func(messages ...string) Requester {
+			return RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+				calls++
+				if calls <= len(messages) {
+					return &AbortError{Identity: h.Identity, Action: agent + "#" + action, Message: messages[calls-1]}
+				}
+
+				handler(nil, &rpc.RPCReply{Data: []byte(`{"message":"configured"}`)})
+
+				return nil
+			})
+		}
+
+		BeforeEach(func() {
+			calls = 0
+			abortRetryInterval = time.Millisecond
+			h.cfg.RetryableAborts = []string{"temporarily unwritable", "/^busy/"}
+			h.cfg.AbortRetries = 3
+		})
+
+		AfterEach(func() {
+			abortRetryInterval = time.Second
+		})
+
+		It("Should retry retryable aborts", func() {
+			h.requester = aborting("ssldir temporarily unwritable", "busy, try later")
+
+			r, err := h.client().Configure(context.Background(), &provision.ConfigureRequest{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Message).To(Equal("configured"))
+			Expect(calls).To(Equal(3))
+		})
+
+		It("Should fail immediately on other aborts", func() {
+			h.requester = aborting("Incorrect provision token supplied")
+
+			_, err := h.client().Configure(context.Background(), &provision.ConfigureRequest{})
+			Expect(err).To(MatchError("ginkgo.example.net aborted choria_provision#configure: Incorrect provision token supplied"))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("Should give up after the configured retries", func() {
+			h.requester = aborting("busy", "busy", "busy", "busy")
+
+			_, err := h.client().Configure(context.Background(), &provision.ConfigureRequest{})
+			Expect(err).To(MatchError("ginkgo.example.net aborted choria_provision#configure: busy"))
+			Expect(calls).To(Equal(3))
+		})
+
+		It("Should not retry when no aborts are retryable", func() {
+			h.cfg.RetryableAborts = []string{}
+			h.requester = aborting("busy")
+
+			_, err := h.client().Configure(context.Background(), &provision.ConfigureRequest{})
+			Expect(err).To(HaveOccurred())
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("SetCaller", func() {
+		It("Should default to the provisioning framework", func() {
+			fw := &choria.Framework{}
+			h.fw = fw
+
+			Expect(h.framework()).To(BeIdenticalTo(fw))
+		})
+
+		It("Should use the caller framework when set", func() {
+			fw := &choria.Framework{}
+			caller := &choria.Framework{}
+			h.fw = fw
+
+			h.SetCaller(caller)
+			Expect(h.framework()).To(BeIdenticalTo(caller))
+		})
+	})
+
+	Describe("checkResponseCount", func() {
+		It("Should accept a single response", func() {
+			Expect(checkResponseCount("ginkgo.example.net", 1)).ToNot(HaveOccurred())
+		})
+
+		It("Should detect missing responses", func() {
+			Expect(checkResponseCount("ginkgo.example.net", 0)).To(MatchError("no response received from ginkgo.example.net"))
+		})
+
+		It("Should detect too many responses", func() {
+			err := checkResponseCount("ginkgo.example.net", 2)
+			Expect(err).To(MatchError("received 2 responses from ginkgo.example.net, multiple nodes share this identity"))
+
+			var duplicate *DuplicateIdentityError
+			Expect(errors.As(err, &duplicate)).To(BeTrue())
+			Expect(duplicate.Identity).To(Equal("ginkgo.example.net"))
+			Expect(duplicate.Responses).To(Equal(2))
+		})
+	})
+
+	Describe("checkResponses", func() {
+		It("Should fail on duplicate identities by default", func() {
+			Expect(h.checkResponses(1, true)).To(Succeed())
+			Expect(h.checkResponses(3, true)).To(MatchError("received 3 responses from ginkgo.example.net, multiple nodes share this identity"))
+		})
+
+		It("Should accept the first valid response when configured", func() {
+			h.cfg.AcceptDuplicateResponses = true
+
+			Expect(h.checkResponses(3, true)).To(Succeed())
+			Expect(h.checkResponses(3, false)).To(HaveOccurred())
+			Expect(h.checkResponses(0, false)).To(MatchError("no response received from ginkgo.example.net"))
+		})
+	})
+
+	Describe("withDeadline", func() {
+		It("Should run without a deadline when not configured", func() {
+			Expect(h.withDeadline(context.Background(), func(ctx context.Context) error {
+				_, ok := ctx.Deadline()
+				Expect(ok).To(BeFalse())
+				return nil
+			})).To(Succeed())
+		})
+
+		It("Should pass through results that complete in time", func() {
+			h.cfg.ProvisionTimeoutDuration = time.Second
+
+			Expect(h.withDeadline(context.Background(), func(_ context.Context) error {
+				return errors.New("simulated")
+			})).To(MatchError("simulated"))
+		})
+
+		It("Should fail slow steps", func() {
+			h.cfg.ProvisionTimeoutDuration = 20 * time.Millisecond
+			release := make(chan struct{})
+			defer close(release)
+
+			start := time.Now()
+			err := h.withDeadline(context.Background(), func(_ context.Context) error {
+				<-release
+				return nil
+			})
+			Expect(err).To(MatchError("provisioning ginkgo.example.net did not complete within 20ms"))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("Should cancel remaining steps", func() {
+			h.cfg.ProvisionTimeoutDuration = 20 * time.Millisecond
+
+			err := h.withDeadline(context.Background(), func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			Expect(err).To(MatchError("provisioning ginkgo.example.net did not complete within 20ms: context deadline exceeded"))
+		})
+	})
+
+	Describe("postWebhook", func() {
+		var (
+			received []webhookNotification
+			failures int
+			srv      *httptest.Server
+			rmu      sync.Mutex
+		)
+
+		BeforeEach(func() {
+			received = []webhookNotification{}
+			failures = 0
+			webhookRetryInterval = time.Millisecond
+
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rmu.Lock()
+				defer rmu.Unlock()
+
+				if failures > 0 {
+					failures--
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				n := webhookNotification{}
+				Expect(json.NewDecoder(r.Body).Decode(&n)).To(Succeed())
+				received = append(received, n)
+			}))
+
+			h.cfg.Site = "ginkgo"
+			h.cfg.WebhookURL = srv.URL
+			h.cfg.WebhookTimeoutDuration = time.Second
+			h.cfg.WebhookRetries = 3
+		})
+
+		AfterEach(func() {
+			srv.Close()
+			webhookRetryInterval = 2 * time.Second
+		})
+
+		It("Should post successful outcomes", func() {
+			h.postWebhook(context.Background(), nil)
+
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].Identity).To(Equal("ginkgo.example.net"))
+			Expect(received[0].Site).To(Equal("ginkgo"))
+			Expect(received[0].Success).To(BeTrue())
+			Expect(received[0].Error).To(BeEmpty())
+		})
+
+		It("Should post failed outcomes", func() {
+			h.postWebhook(context.Background(), errors.New("simulated"))
+
+			Expect(received).To(HaveLen(1))
+			Expect(received[0].Success).To(BeFalse())
+			Expect(received[0].Error).To(Equal("simulated"))
+		})
+
+		It("Should retry failed deliveries", func() {
+			failures = 2
+			h.postWebhook(context.Background(), nil)
+
+			Expect(received).To(HaveLen(1))
+		})
+
+		It("Should give up after the configured retries", func() {
+			failures = 5
+			h.postWebhook(context.Background(), nil)
+
+			Expect(received).To(BeEmpty())
+			Expect(failures).To(Equal(2))
+		})
+
+		It("Should tolerate unreachable webhooks", func() {
+			srv.Close()
+			h.postWebhook(context.Background(), nil)
+
+			Expect(received).To(BeEmpty())
+		})
+	})
+
+	Describe("ProvisionBatch", func() {
+		var (
+			batch    *BatchProvisioner
+			failures map[string]int
+			fmu      sync.Mutex
+		)
+
+		BeforeEach(func() {
+			failures = map[string]int{}
+			h.cfg.Workers = 2
+			h.cfg.MaxAttempts = 3
+			batch = NewBatchProvisioner(nil, h.cfg)
+
+			provisionFunc = func(_ context.Context, host *Host, _ *choria.Framework) error {
+				fmu.Lock()
+				defer fmu.Unlock()
+
+				if failures[host.Identity] > 0 {
+					failures[host.Identity]--
+					return fmt.Errorf("simulated failure for %s", host.Identity)
+				}
+
+				return nil
+			}
+		})
+
+		AfterEach(func() {
+			provisionFunc = func(ctx context.Context, h *Host, fw *choria.Framework) error {
+				return h.Provision(ctx, fw)
+			}
+		})
+
+		identities := func(results []*HostResult) []string {
+			ids := []string{}
+			for _, r := range results {
+				ids = append(ids, r.Identity)
+			}
+			return ids
+		}
+
+		It("Should separate succeeded, failed and dead lettered hosts", func() {
+			failures["flaky.example.net"] = 1
+			failures["broken.example.net"] = 100
+
+			batch.Attempts.Failed("broken.example.net", errors.New("earlier failure"))
+			batch.Attempts.Failed("broken.example.net", errors.New("earlier failure"))
+
+			hosts := []*Host{NewHost("good.example.net", h.cfg), NewHost("flaky.example.net", h.cfg), NewHost("broken.example.net", h.cfg)}
+			result := batch.ProvisionBatch(context.Background(), hosts)
+
+			Expect(identities(result.WithStatus(StatusSucceeded))).To(Equal([]string{"good.example.net"}))
+			Expect(identities(result.WithStatus(StatusFailed))).To(Equal([]string{"flaky.example.net"}))
+			Expect(result.WithStatus(StatusFailed)[0].Error).To(Equal("simulated failure for flaky.example.net"))
+			Expect(identities(result.WithStatus(StatusDeadLettered))).To(Equal([]string{"broken.example.net"}))
+			Expect(result.WithStatus(StatusDeadLettered)[0].Error).To(Equal("simulated failure for broken.example.net"))
+			Expect(result.Counts).To(Equal(BatchCounts{Total: 3, Succeeded: 1, Failed: 1, DeadLettered: 1}))
+			Expect(batch.Attempts.Attempts("flaky.example.net")).To(Equal(1))
+		})
+
+		It("Should eventually dead letter flaky hosts and skip them afterwards", func() {
+			failures["flaky.example.net"] = 100
+			hosts := []*Host{NewHost("good.example.net", h.cfg), NewHost("flaky.example.net", h.cfg)}
+
+			for i := 1; i < 3; i++ {
+				result := batch.ProvisionBatch(context.Background(), hosts)
+				Expect(identities(result.WithStatus(StatusFailed))).To(Equal([]string{"flaky.example.net"}))
+				Expect(result.WithStatus(StatusDeadLettered)).To(BeEmpty())
+			}
+
+			result := batch.ProvisionBatch(context.Background(), hosts)
+			Expect(result.WithStatus(StatusFailed)).To(BeEmpty())
+			Expect(identities(result.WithStatus(StatusDeadLettered))).To(Equal([]string{"flaky.example.net"}))
+
+			result = batch.ProvisionBatch(context.Background(), hosts)
+			Expect(identities(result.WithStatus(StatusSucceeded))).To(Equal([]string{"good.example.net"}))
+			Expect(identities(result.WithStatus(StatusDeadLettered))).To(Equal([]string{"flaky.example.net"}))
+			Expect(result.WithStatus(StatusDeadLettered)[0].Error).To(Equal("simulated failure for flaky.example.net"))
+			Expect(failures["flaky.example.net"]).To(Equal(97))
+
+			batch.Attempts.Clear("flaky.example.net")
+			failures["flaky.example.net"] = 0
+			result = batch.ProvisionBatch(context.Background(), hosts)
+			Expect(identities(result.WithStatus(StatusSucceeded))).To(Equal([]string{"flaky.example.net", "good.example.net"}))
+		})
+
+		It("Should quarantine hosts failing verification and skip them afterwards", func() {
+			h.cfg.QuarantineAfter = 2
+			batch = NewBatchProvisioner(nil, h.cfg)
+			hosts := []*Host{NewHost("good.example.net", h.cfg), NewHost("unverified.example.net", h.cfg)}
+
+			provisionFunc = func(_ context.Context, host *Host, _ *choria.Framework) error {
+				if host.Identity == "unverified.example.net" {
+					return fmt.Errorf("restart failed: %w", &VerifyError{Identity: host.Identity, Reason: "started in provisioning mode after restarting"})
+				}
+
+				return nil
+			}
+
+			result := batch.ProvisionBatch(context.Background(), hosts)
+			Expect(identities(result.WithStatus(StatusFailed))).To(Equal([]string{"unverified.example.net"}))
+			Expect(result.Quarantined).To(BeEmpty())
+
+			result = batch.ProvisionBatch(context.Background(), hosts)
+			Expect(identities(result.WithStatus(StatusQuarantined))).To(Equal([]string{"unverified.example.net"}))
+			Expect(result.Counts).To(Equal(BatchCounts{Total: 2, Succeeded: 1, Quarantined: 1}))
+			Expect(result.Quarantined).To(HaveLen(1))
+			Expect(result.Quarantined[0].Identity).To(Equal("unverified.example.net"))
+			Expect(result.Quarantined[0].Reason).To(Equal("unverified.example.net started in provisioning mode after restarting"))
+			Expect(result.Quarantined[0].Failures).To(Equal(2))
+
+			provisionFunc = func(context.Context, *Host, *choria.Framework) error {
+				defer GinkgoRecover()
+				Fail("quarantined hosts should not be provisioned")
+				return nil
+			}
+
+			result = batch.ProvisionBatch(context.Background(), hosts[1:])
+			Expect(identities(result.WithStatus(StatusQuarantined))).To(Equal([]string{"unverified.example.net"}))
+
+			batch.Quarantine.Clear("unverified.example.net")
+			provisionFunc = func(context.Context, *Host, *choria.Framework) error { return nil }
+
+			result = batch.ProvisionBatch(context.Background(), hosts[1:])
+			Expect(identities(result.WithStatus(StatusSucceeded))).To(Equal([]string{"unverified.example.net"}))
+			Expect(result.Quarantined).To(BeEmpty())
+		})
+
+		It("Should produce a JSON report", func() {
+			h.cfg.Site = "ginkgo"
+			failures["flaky.example.net"] = 1
+			hosts := []*Host{NewHost("good.example.net", h.cfg), NewHost("flaky.example.net", h.cfg)}
+
+			result := batch.ProvisionBatch(context.Background(), hosts)
+			j, err := result.JSON()
+			Expect(err).ToNot(HaveOccurred())
+
+			report := map[string]interface{}{}
+			Expect(json.Unmarshal(j, &report)).To(Succeed())
+			Expect(report).To(HaveKeyWithValue("site", "ginkgo"))
+			Expect(report).To(HaveKey("provisioner"))
+			Expect(report).To(HaveKey("start"))
+			Expect(report).To(HaveKey("end"))
+			Expect(report["counts"]).To(Equal(map[string]interface{}{"total": 2.0, "succeeded": 1.0, "failed": 1.0, "dead_lettered": 0.0, "quarantined": 0.0}))
+
+			hr := report["hosts"].([]interface{})
+			Expect(hr).To(HaveLen(2))
+			Expect(hr[0]).To(HaveKeyWithValue("identity", "flaky.example.net"))
+			Expect(hr[0]).To(HaveKeyWithValue("status", "failed"))
+			Expect(hr[0]).To(HaveKeyWithValue("error", "simulated failure for flaky.example.net"))
+			Expect(hr[0]).To(HaveKey("duration"))
+			Expect(hr[1]).To(HaveKeyWithValue("identity", "good.example.net"))
+			Expect(hr[1]).To(HaveKeyWithValue("status", "succeeded"))
+			Expect(hr[1]).ToNot(HaveKey("error"))
+		})
+	})
+
+	Describe("Quarantine", func() {
+		verr := fmt.Errorf("restart failed: %w", &VerifyError{Identity: "ginkgo.example.net", Reason: "did not start within 1s after restarting"})
+
+		It("Should only count verification failures", func() {
+			q := NewQuarantine(1, 0, "ginkgo")
+
+			Expect(q.Failed("ginkgo.example.net", errors.New("could not fetch inventory"))).To(BeFalse())
+			_, quarantined := q.Quarantined("ginkgo.example.net")
+			Expect(quarantined).To(BeFalse())
+
+			Expect(q.Failed("ginkgo.example.net", verr)).To(BeTrue())
+			qh, quarantined := q.Quarantined("ginkgo.example.net")
+			Expect(quarantined).To(BeTrue())
+			Expect(qh.Reason).To(Equal("ginkgo.example.net did not start within 1s after restarting"))
+			Expect(qh.Time).To(BeTemporally("~", time.Now(), time.Second))
+		})
+
+		It("Should reset failures on success", func() {
+			q := NewQuarantine(2, 0, "ginkgo")
+
+			Expect(q.Failed("ginkgo.example.net", verr)).To(BeFalse())
+			q.Succeeded("ginkgo.example.net")
+			Expect(q.Failed("ginkgo.example.net", verr)).To(BeFalse())
+			Expect(q.Failed("ginkgo.example.net", verr)).To(BeTrue())
+		})
+
+		It("Should never quarantine when disabled", func() {
+			q := NewQuarantine(0, 0, "ginkgo")
+
+			for i := 0; i < 10; i++ {
+				Expect(q.Failed("ginkgo.example.net", verr)).To(BeFalse())
+			}
+			Expect(q.Hosts()).To(BeEmpty())
+		})
+
+		It("Should release hosts after the cooldown or when cleared", func() {
+			q := NewQuarantine(1, 50*time.Millisecond, "ginkgo")
+
+			Expect(q.Failed("ginkgo.example.net", verr)).To(BeTrue())
+			Expect(q.Failed("other.example.net", verr)).To(BeTrue())
+			Expect(q.Hosts()).To(HaveLen(2))
+
+			q.Clear("other.example.net")
+			Expect(q.Hosts()).To(HaveLen(1))
+			Expect(q.Hosts()[0].Identity).To(Equal("ginkgo.example.net"))
+
+			Eventually(func() bool {
+				_, quarantined := q.Quarantined("ginkgo.example.net")
+				return quarantined
+			}).Should(BeFalse())
+			Expect(q.Hosts()).To(BeEmpty())
+		})
+	})
+
+	Describe("verifyRestart", func() {
+		BeforeEach(func() {
+			h.cfg.LifecycleComponent = "provision_mode_server"
+			h.cfg.RestartVerifyTimeoutDuration = 20 * time.Millisecond
+		})
+
+		It("Should accept nodes starting outside of provisioning mode", func() {
+			started := make(chan string, 1)
+			started <- "server"
+
+			Expect(h.verifyRestart(context.Background(), started)).To(Succeed())
+		})
+
+		It("Should fail with a verification error otherwise", func() {
+			started := make(chan string, 1)
+			started <- "provision_mode_server"
+
+			err := h.verifyRestart(context.Background(), started)
+			var verr *VerifyError
+			Expect(errors.As(err, &verr)).To(BeTrue())
+			Expect(err).To(MatchError("ginkgo.example.net started in provisioning mode after restarting"))
+
+			err = h.verifyRestart(context.Background(), make(chan string))
+			Expect(errors.As(err, &verr)).To(BeTrue())
+			Expect(err).To(MatchError("ginkgo.example.net did not start within 20ms after restarting"))
+		})
+	})
+
+	Describe("Signer", func() {
+		var (
+			td     string
+			caCert []byte
+			err    error
+		)
+
+		BeforeEach(func() {
+			td, err = ioutil.TempDir("", "")
+			Expect(err).ToNot(HaveOccurred())
+
+			var caKey []byte
+			caCert, caKey, err = genca()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(td, "ca.pem"), caCert, 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(td, "ca-key.pem"), caKey, 0600)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(td)
+		})
+
+		It("Should refuse certificates that are not a CA", func() {
+			cert, err := gencert("ginkgo.example.net")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(td, "cert.pem"), cert, 0600)).To(Succeed())
+
+			_, err = NewSigner(filepath.Join(td, "cert.pem"), filepath.Join(td, "ca-key.pem"), time.Hour)
+			Expect(err).To(MatchError(fmt.Sprintf("certificate %s is not a CA", filepath.Join(td, "cert.pem"))))
+		})
+
+		It("Should sign CSRs that verify against the CA", func() {
+			signer, err := NewSigner(filepath.Join(td, "ca.pem"), filepath.Join(td, "ca-key.pem"), time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+
+			csr, _, err := gencsr("ginkgo.example.net", []string{"alt.example.net"})
+			Expect(err).ToNot(HaveOccurred())
+
+			certPEM, ca, err := signer.Sign(&provision.CSRReply{CSR: string(csr)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ca).To(Equal(string(caCert)))
+
+			block, _ := pem.Decode([]byte(certPEM))
+			cert, err := x509.ParseCertificate(block.Bytes)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Subject.CommonName).To(Equal("ginkgo.example.net"))
+			Expect(cert.DNSNames).To(Equal([]string{"alt.example.net"}))
+			Expect(cert.NotAfter).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+			pool := x509.NewCertPool()
+			Expect(pool.AppendCertsFromPEM([]byte(ca))).To(BeTrue())
+
+			_, err = cert.Verify(x509.VerifyOptions{
+				Roots:     pool,
+				DNSName:   "alt.example.net",
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should use unique serials", func() {
+			signer, err := NewSigner(filepath.Join(td, "ca.pem"), filepath.Join(td, "ca-key.pem"), time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+
+			csr, _, err := gencsr("ginkgo.example.net", []string{})
+			Expect(err).ToNot(HaveOccurred())
+
+			serials := map[string]bool{}
+			for i := 0; i < 2; i++ {
+				certPEM, _, err := signer.Sign(&provision.CSRReply{CSR: string(csr)})
+				Expect(err).ToNot(HaveOccurred())
+
+				block, _ := pem.Decode([]byte(certPEM))
+				cert, err := x509.ParseCertificate(block.Bytes)
+				Expect(err).ToNot(HaveOccurred())
+				serials[cert.SerialNumber.String()] = true
+			}
+
+			Expect(serials).To(HaveLen(2))
+		})
+	})
+
+	Describe("Plan", func() {
+		var (
+			td       string
+			requests []string
+		)
+
+		BeforeEach(func() {
+			var err error
+			td, err = ioutil.TempDir("", "")
+			Expect(err).ToNot(HaveOccurred())
+
+			helper := filepath.Join(td, "helper")
+			Expect(ioutil.WriteFile(helper, []byte(`#!/bin/sh
+grep -q '"dry_run":true' || exit 1
+echo '{"defer":false,"certificate":"cert","ca":"ca","configuration":{"identity":"ginkgo.example.net","plugin.choria.provision.token":"s3cret"}}'
+`), 0700)).To(Succeed())
+
+			requests = []string{}
+			h.cfg.Helper = helper
+			h.cfg.Features.PKI = true
+			h.requester = RequesterFunc(func(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+				requests = append(requests, fmt.Sprintf("%s#%s", agent, action))
+
+				if action == "inventory" {
+					handler(nil, &rpc.RPCReply{Data: []byte(`{"agents":["choria_provision","rpcutil"],"facts":{}}`)})
+				}
+
+				return nil
+			})
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(td)
+		})
+
+		It("Should plan without changing the node", func() {
+			plan, err := h.Plan(context.Background(), nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(requests).To(Equal([]string{"rpcutil#inventory"}))
+			Expect(h.DryRun).To(BeFalse())
+			Expect(plan).To(Equal(&ProvisionPlan{
+				Identity:      "ginkgo.example.net",
+				CSRCommonName: "ginkgo.example.net",
+				Configuration: map[string]string{
+					"identity":                      "ginkgo.example.net",
+					"plugin.choria.provision.token": "[REDACTED]",
+				},
+				Certificate: true,
+				CA:          true,
+				Restart:     true,
+			}))
+		})
+	})
+
+	Describe("notify", func() {
+		It("Should call the success callback with host details", func() {
+			cert, err := gencert("ginkgo.example.net")
+			Expect(err).ToNot(HaveOccurred())
+
+			h.cert = string(cert)
+			h.Metadata = `{"agents":["choria_provision"]}`
+
+			var result ProvisionResult
+			var failed bool
+			h.OnSuccess(func(r ProvisionResult) { result = r })
+			h.OnFailure(func(r ProvisionResult) { failed = true })
+
+			h.notify(nil)
+
+			block, _ := pem.Decode(cert)
+			Expect(failed).To(BeFalse())
+			Expect(result.Identity).To(Equal("ginkgo.example.net"))
+			Expect(result.Metadata).To(Equal(h.Metadata))
+			Expect(result.CertFingerprint).To(Equal(fmt.Sprintf("%x", sha256.Sum256(block.Bytes))))
+			Expect(result.Error).ToNot(HaveOccurred())
+		})
+
+		It("Should call the failure callback with the error", func() {
+			var result ProvisionResult
+			var succeeded bool
+			h.OnSuccess(func(r ProvisionResult) { succeeded = true })
+			h.OnFailure(func(r ProvisionResult) { result = r })
+
+			h.notify(errors.New("simulated"))
+
+			Expect(succeeded).To(BeFalse())
+			Expect(result.Identity).To(Equal("ginkgo.example.net"))
+			Expect(result.Error).To(MatchError("simulated"))
+		})
+
+		It("Should not block on slow callbacks", func() {
+			callbackTimeout = 10 * time.Millisecond
+			defer func() { callbackTimeout = 10 * time.Second }()
+
+			release := make(chan struct{})
+			defer close(release)
+
+			h.OnFailure(func(r ProvisionResult) { <-release })
+
+			start := time.Now()
+			h.notify(errors.New("simulated"))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+	})
 })
 
+func genjwt(key []byte, expires time.Duration) (string, error) {
+	k, err := jwt.ParseRSAPrivateKeyFromPEM(key)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &provClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   "ginkgo",
+			Subject:  "ginkgo.example.net",
+			IssuedAt: time.Now().Unix(),
+		},
+	}
+
+	if expires != 0 {
+		claims.ExpiresAt = time.Now().Add(expires).Unix()
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(k)
+}
+
+func genca() (cert []byte, key []byte, err error) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Ginkgo CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &k.PublicKey, k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+
+	return cert, key, nil
+}
+
+func gencert(cn string) (cert []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
 func gencsr(cn string, altnames []string) (csr []byte, key []byte, err error) {
 	if cn == "" {
 		return csr, key, fmt.Errorf("common name is required")
@@ -0,0 +1,55 @@
+package host
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWTStatus describes the provisioning JWT obtained from a node
+type JWTStatus struct {
+	Issuer    string        `json:"issuer"`
+	Subject   string        `json:"subject"`
+	ExpiresAt time.Time     `json:"expires_at,omitempty"`
+	Remaining time.Duration `json:"remaining"`
+}
+
+// JWTStatus reports the issuer, subject and remaining validity of the validated provisioning JWT,
+// Remaining is 0 for tokens without an expiry time
+func (h *Host) JWTStatus() (*JWTStatus, error) {
+	if h.JWT == nil {
+		return nil, fmt.Errorf("no validated JWT for %s", h.Identity)
+	}
+
+	status := &JWTStatus{
+		Issuer:  h.JWT.Issuer,
+		Subject: h.JWT.Subject,
+	}
+
+	if h.JWT.ExpiresAt > 0 {
+		status.ExpiresAt = time.Unix(h.JWT.ExpiresAt, 0)
+		status.Remaining = time.Until(status.ExpiresAt)
+	}
+
+	return status, nil
+}
+
+// jwtNeedsRefresh determines if the JWT held for the host has expired or expires within the refresh window
+func (h *Host) jwtNeedsRefresh() bool {
+	if h.rawJWT == "" {
+		return true
+	}
+
+	claims := &provClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(h.rawJWT, claims)
+	if err != nil {
+		return true
+	}
+
+	if claims.ExpiresAt == 0 {
+		return false
+	}
+
+	return time.Until(time.Unix(claims.ExpiresAt, 0)) <= h.cfg.JWTRefreshWindowDuration
+}
@@ -0,0 +1,72 @@
+package host
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/provisioning-agent/config"
+)
+
+// pacer limits how many operations may start within any window of time
+type pacer struct {
+	window time.Duration
+	slots  chan struct{}
+}
+
+var (
+	restartPacer     *pacer
+	reprovisionPacer *pacer
+	pacerMu          = &sync.Mutex{}
+)
+
+func newPacer(max int, window time.Duration) *pacer {
+	return &pacer{
+		window: window,
+		slots:  make(chan struct{}, max),
+	}
+}
+
+// wait blocks until an operation may start, each started operation holds its slot for the duration of
+// the window, throttled indicates the operation could not start immediately
+func (p *pacer) wait(ctx context.Context) (throttled bool, err error) {
+	select {
+	case p.slots <- struct{}{}:
+		time.AfterFunc(p.window, func() { <-p.slots })
+		return false, nil
+	default:
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		time.AfterFunc(p.window, func() { <-p.slots })
+		return true, nil
+
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// restartPacing is the pacer shared by all hosts restarting nodes, nil when restarts are not paced
+func restartPacing(cfg *config.Config) *pacer {
+	pacerMu.Lock()
+	defer pacerMu.Unlock()
+
+	if restartPacer == nil && cfg.MaxConcurrentRestarts > 0 {
+		restartPacer = newPacer(cfg.MaxConcurrentRestarts, cfg.RestartWindowDuration)
+	}
+
+	return restartPacer
+}
+
+// reprovisionPacing is the pacer shared by all hosts reprovisioning nodes, nil when reprovisions are not paced
+func reprovisionPacing(cfg *config.Config) *pacer {
+	pacerMu.Lock()
+	defer pacerMu.Unlock()
+
+	if reprovisionPacer == nil && cfg.MaxReprovisions > 0 {
+		reprovisionPacer = newPacer(cfg.MaxReprovisions, cfg.ReprovisionWindowDuration)
+	}
+
+	return reprovisionPacer
+}
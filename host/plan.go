@@ -0,0 +1,87 @@
+package host
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/choria-io/go-choria/choria"
+)
+
+// ProvisionPlan describes what provisioning a host would do
+type ProvisionPlan struct {
+	Identity      string            `json:"identity"`
+	CSRCommonName string            `json:"csr_common_name,omitempty"`
+	SignCSR       bool              `json:"sign_csr"`
+	Configuration map[string]string `json:"configuration"`
+	Certificate   bool              `json:"certificate"`
+	CA            bool              `json:"ca"`
+	Restart       bool              `json:"restart"`
+	Deferred      bool              `json:"deferred"`
+	DeferMessage  string            `json:"defer_message,omitempty"`
+}
+
+// Plan determines what Provision would do without changing anything on the node, only the JWT
+// and inventory are retrieved from the node and the helper is called with dry_run set. Secrets in
+// the configuration are redacted.
+func (h *Host) Plan(ctx context.Context, fw *choria.Framework) (*ProvisionPlan, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fw = fw
+	if fw != nil {
+		h.log = fw.Logger(h.Identity)
+	}
+
+	h.DryRun = true
+	defer func() { h.DryRun = false }()
+
+	if h.cfg.Features.JWT {
+		err := h.fetchJWT(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch and validate JWT: %s: %s", h.Identity, err)
+		}
+
+		err = h.validateJWT()
+		if err != nil {
+			return nil, fmt.Errorf("could not validate JWT: %s: %s", h.Identity, err)
+		}
+	}
+
+	err := h.fetchInventory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not plan %s: %s", h.Identity, err)
+	}
+
+	plan := &ProvisionPlan{Identity: h.Identity}
+
+	if h.cfg.Features.PKI {
+		plan.CSRCommonName = h.Identity
+	}
+
+	config, err := h.getConfig(ctx)
+	if err != nil {
+		helperErrCtr.WithLabelValues(h.cfg.Site).Inc()
+		return nil, err
+	}
+
+	if config.Defer {
+		plan.Deferred = true
+		plan.DeferMessage = config.Msg
+
+		return plan, nil
+	}
+
+	h.config = config.Configuration
+	err = h.validateConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err)
+	}
+
+	plan.Configuration = h.cfg.RedactStrings(config.Configuration)
+	plan.SignCSR = h.cfg.Features.PKI && h.cfg.CACert != "" && config.Certificate == ""
+	plan.Certificate = config.Certificate != "" || plan.SignCSR
+	plan.CA = config.CA != "" || plan.SignCSR
+	plan.Restart = true
+
+	return plan, nil
+}
@@ -0,0 +1,136 @@
+package host
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuarantinedHost is a host that repeatedly failed verification after provisioning
+type QuarantinedHost struct {
+	Identity string    `json:"identity"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+	Failures int       `json:"failures"`
+}
+
+// Quarantine tracks hosts failing verification after provisioning, hosts failing too often are
+// quarantined until cleared or until the cooldown elapsed
+type Quarantine struct {
+	after       int
+	cooldown    time.Duration
+	site        string
+	failures    map[string]int
+	quarantined map[string]*QuarantinedHost
+	mu          *sync.Mutex
+}
+
+// NewQuarantine creates a quarantine for hosts that failed verification after times, 0 means never.
+// Quarantined hosts are released after cooldown, 0 keeps them quarantined until cleared
+func NewQuarantine(after int, cooldown time.Duration, site string) *Quarantine {
+	return &Quarantine{
+		after:       after,
+		cooldown:    cooldown,
+		site:        site,
+		failures:    make(map[string]int),
+		quarantined: make(map[string]*QuarantinedHost),
+		mu:          &sync.Mutex{},
+	}
+}
+
+// Failed records a failed provisioning attempt and reports if the host is now quarantined, only
+// verification failures are counted
+func (q *Quarantine) Failed(identity string, err error) bool {
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.failures[identity]++
+
+	if q.after > 0 && q.failures[identity] >= q.after {
+		q.quarantined[identity] = &QuarantinedHost{
+			Identity: identity,
+			Reason:   verr.Error(),
+			Time:     time.Now(),
+			Failures: q.failures[identity],
+		}
+		delete(q.failures, identity)
+		q.updateGauge()
+
+		return true
+	}
+
+	return false
+}
+
+// Succeeded clears the verification failure history of a host
+func (q *Quarantine) Succeeded(identity string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.failures, identity)
+}
+
+// Quarantined reports if a host is quarantined, hosts whose cooldown elapsed are released
+func (q *Quarantine) Quarantined(identity string) (*QuarantinedHost, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+
+	qh, ok := q.quarantined[identity]
+
+	return qh, ok
+}
+
+// Hosts are all quarantined hosts sorted by identity
+func (q *Quarantine) Hosts() []*QuarantinedHost {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+
+	result := make([]*QuarantinedHost, 0, len(q.quarantined))
+	for _, qh := range q.quarantined {
+		c := *qh
+		result = append(result, &c)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Identity < result[j].Identity })
+
+	return result
+}
+
+// Clear releases a host from quarantine and resets its verification failures
+func (q *Quarantine) Clear(identity string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.quarantined, identity)
+	delete(q.failures, identity)
+	q.updateGauge()
+}
+
+// expire releases hosts whose cooldown elapsed, expects the lock to be held
+func (q *Quarantine) expire() {
+	if q.cooldown <= 0 {
+		return
+	}
+
+	for identity, qh := range q.quarantined {
+		if time.Since(qh.Time) >= q.cooldown {
+			delete(q.quarantined, identity)
+		}
+	}
+
+	q.updateGauge()
+}
+
+func (q *Quarantine) updateGauge() {
+	quarantinedGauge.WithLabelValues(q.site).Set(float64(len(q.quarantined)))
+}
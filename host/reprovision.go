@@ -0,0 +1,45 @@
+package host
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc/golang/provision"
+)
+
+// Reprovision requests the node to leave its collective and enter provisioning mode again after a
+// splay of up to splay seconds, reprovisions across all hosts are paced using max_reprovisions
+func (h *Host) Reprovision(ctx context.Context, fw *choria.Framework, splay int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fw = fw
+	if fw != nil {
+		h.log = fw.Logger(h.Identity)
+	}
+
+	pacing := reprovisionPacing(h.cfg)
+	if pacing != nil {
+		throttled, err := pacing.wait(ctx)
+		if throttled {
+			reprovisionThrottledCtr.WithLabelValues(h.cfg.Site).Inc()
+		}
+		if err != nil {
+			return fmt.Errorf("could not obtain a reprovision slot: %s", err)
+		}
+	}
+
+	h.log.Info("Reprovisioning node")
+
+	r, err := h.client().Reprovision(ctx, &provision.ReprovisionRequest{Splay: splay})
+	if err != nil {
+		return err
+	}
+
+	h.log.Infof("Reprovision response: %s", r.Message)
+
+	h.provisioned = false
+
+	return nil
+}
@@ -3,7 +3,10 @@ package host
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/choria-io/go-choria/protocol"
 	"github.com/choria-io/go-choria/providers/agent/mcorpc"
@@ -28,12 +31,18 @@ func (h *Host) rpcDo(ctx context.Context, agent string, action string, input int
 		return nil, fmt.Errorf("could not find DDL for agent choria_provision in the agent cache")
 	}
 
-	prov, err := rpc.New(h.fw, agent, rpc.DDL(ddl))
+	fw := h.framework()
+	h.log.Debugf("Performing %s as %s", name, fw.CallerID())
+
+	prov, err := rpc.New(fw, agent, rpc.DDL(ddl))
 	if err != nil {
 		rpcErrCtr.WithLabelValues(h.cfg.Site, name).Inc()
 		return nil, fmt.Errorf("could not create %s client: %s", agent, err)
 	}
 
+	var abort *AbortError
+	var handled bool
+
 	handler := func(pr protocol.Reply, reply *rpc.RPCReply) {
 		h.replylock.Lock()
 		defer h.replylock.Unlock()
@@ -41,49 +50,154 @@ func (h *Host) rpcDo(ctx context.Context, agent string, action string, input int
 		if reply.Statuscode != mcorpc.OK {
 			rpcErrCtr.WithLabelValues(h.cfg.Site, name).Inc()
 			h.log.Errorf("Failed reply from %s: %s", pr.SenderID(), reply.Statusmsg)
+
+			if reply.Statuscode == mcorpc.Aborted && pr.SenderID() == h.Identity {
+				abort = &AbortError{Identity: h.Identity, Action: name, Message: reply.Statusmsg}
+			}
+
 			return
 		}
 
-		if pr.SenderID() == h.Identity {
-			cb(pr, reply)
+		if pr.SenderID() != h.Identity {
+			return
 		}
+
+		// with duplicate identities only the first valid reply is used when those are accepted
+		if handled && h.cfg.AcceptDuplicateResponses {
+			return
+		}
+
+		handled = true
+		cb(pr, reply)
+	}
+
+	opts := []rpc.RequestOption{
+		rpc.Targets([]string{h.Identity}),
+		rpc.Collective("provisioning"),
+		rpc.ReplyHandler(handler),
+		rpc.Workers(1),
 	}
 
-	result, err := prov.Do(ctx, action, input, rpc.Targets([]string{h.Identity}), rpc.Collective("provisioning"), rpc.ReplyHandler(handler), rpc.Workers(1))
+	// sites may set their own timeout and the grace allows slow nodes extra time to deliver their single expected reply
+	timeout := time.Duration(ddl.Metadata.Timeout) * time.Second
+	if d := h.defaults(); d.RequestTimeoutDuration > 0 {
+		timeout = d.RequestTimeoutDuration
+	}
+	opts = append(opts, rpc.Timeout(timeout+h.cfg.ReplyGraceDuration))
+
+	if len(h.brokers) > 0 {
+		conn, err := h.connect(ctx)
+		if err != nil {
+			rpcErrCtr.WithLabelValues(h.cfg.Site, name).Inc()
+			return nil, fmt.Errorf("could not perform %s#%s: %s", agent, action, err)
+		}
+		defer conn.Close()
+
+		opts = append(opts, rpc.Connection(conn))
+	}
+
+	result, err := prov.Do(ctx, action, input, opts...)
 	if err != nil {
 		rpcErrCtr.WithLabelValues(h.cfg.Site, name).Inc()
 		return nil, fmt.Errorf("could not perform %s#%s: %s", agent, action, err)
 	}
 
-	if result.Stats().ResponsesCount() != 1 {
+	err = h.checkResponses(result.Stats().ResponsesCount(), handled)
+	if err != nil {
 		rpcErrCtr.WithLabelValues(h.cfg.Site, name).Inc()
-		return nil, fmt.Errorf("could not perform %s#%s: received %d responses while expecting a response from %s", agent, action, result.Stats().ResponsesCount(), h.Identity)
+		return nil, fmt.Errorf("could not perform %s#%s: %s", agent, action, err)
+	}
+
+	if abort != nil {
+		return result.Stats(), abort
 	}
 
 	return result.Stats(), nil
+}
 
+// DuplicateIdentityError is returned when more than one node responded using the same identity, usually
+// because machines were cloned from an image that already had a certname set
+type DuplicateIdentityError struct {
+	Identity  string
+	Responses int
 }
 
-func (h *Host) restart(ctx context.Context) error {
-	h.log.Info("Restarting node")
+func (e *DuplicateIdentityError) Error() string {
+	return fmt.Sprintf("received %d responses from %s, multiple nodes share this identity", e.Responses, e.Identity)
+}
+
+func checkResponseCount(identity string, count int) error {
+	switch {
+	case count == 0:
+		return fmt.Errorf("no response received from %s", identity)
+	case count > 1:
+		return &DuplicateIdentityError{Identity: identity, Responses: count}
+	}
+
+	return nil
+}
+
+// checkResponses checks the response count of a request, duplicate identities are tolerated when
+// accept_duplicate_responses is set and a valid reply was handled
+func (h *Host) checkResponses(count int, handled bool) error {
+	err := checkResponseCount(h.Identity, count)
 
-	creq := &provision.RestartRequest{
-		Token: h.token,
-		Splay: 1,
+	var duplicate *DuplicateIdentityError
+	if !errors.As(err, &duplicate) {
+		return err
+	}
+
+	duplicateCtr.WithLabelValues(h.cfg.Site, h.Identity).Inc()
+
+	if h.cfg.AcceptDuplicateResponses && handled {
+		h.log.Warnf("Using the first valid reply: %s", err)
+		return nil
 	}
 
-	_, err := h.rpcDo(ctx, "choria_provision", "restart", creq, func(pr protocol.Reply, reply *rpc.RPCReply) {
-		r := &provision.Reply{}
-		err := json.Unmarshal(reply.Data, r)
+	return err
+}
+
+func (h *Host) request(ctx context.Context, agent string, action string, input interface{}, handler rpc.Handler) error {
+	_, err := h.rpcDo(ctx, agent, action, input, handler)
+	return err
+}
+
+// rpcRequester is the Requester used for all requests against the node
+func (h *Host) rpcRequester() Requester {
+	if h.requester != nil {
+		return h.retryAborts(h.requester)
+	}
+
+	return h.retryAborts(RequesterFunc(h.request))
+}
+
+func (h *Host) client() *ProvisionClient {
+	return NewProvisionClient(h.token, h.rpcRequester())
+}
+
+func (h *Host) restart(ctx context.Context) error {
+	pacing := restartPacing(h.cfg)
+	if pacing != nil {
+		h.log.Debugf("Waiting for a restart slot, at most %d restarts are done every %s", h.cfg.MaxConcurrentRestarts, h.cfg.RestartWindowDuration)
+
+		start := time.Now()
+		_, err := pacing.wait(ctx)
 		if err != nil {
-			h.log.Errorf("Could not parse reply from %s: %s", pr.SenderID(), err)
-			return
+			return fmt.Errorf("could not obtain a restart slot: %s", err)
 		}
+		restartWaitDuration.WithLabelValues(h.cfg.Site).Observe(time.Since(start).Seconds())
+	}
 
-		h.log.Infof("Restart response: %s", r.Message)
-	})
+	h.log.Info("Restarting node")
 
-	return err
+	r, err := h.client().Restart(ctx, &provision.RestartRequest{Splay: h.defaults().RestartSplay})
+	if err != nil {
+		return err
+	}
+
+	h.log.Infof("Restart response: %s", r.Message)
+
+	return nil
 }
 
 func (h *Host) configure(ctx context.Context) error {
@@ -92,6 +206,7 @@ func (h *Host) configure(ctx context.Context) error {
 	}
 
 	h.log.Info("Configuring node")
+	h.log.Debugf("Configuration: %v", h.cfg.RedactStrings(h.config))
 
 	cj, err := json.Marshal(h.config)
 	if err != nil {
@@ -99,7 +214,6 @@ func (h *Host) configure(ctx context.Context) error {
 	}
 
 	creq := &provision.ConfigureRequest{
-		Token:         h.token,
 		CA:            h.ca,
 		Certificate:   h.cert,
 		Configuration: string(cj),
@@ -109,52 +223,78 @@ func (h *Host) configure(ctx context.Context) error {
 		creq.SSLDir = h.CSR.SSLDir
 	}
 
-	_, err = h.rpcDo(ctx, "choria_provision", "configure", creq, func(pr protocol.Reply, reply *rpc.RPCReply) {
-		r := &provision.Reply{}
-		err := json.Unmarshal(reply.Data, r)
-		if err != nil {
-			h.log.Errorf("Could not parse reply from %s: %s", pr.SenderID(), err)
-			return
-		}
+	err = h.validateConfigureRequest(creq)
+	if err != nil {
+		return err
+	}
 
-		h.log.Infof("Configuration response: %s", r.Message)
-	})
+	r, err := h.client().Configure(ctx, creq)
+	if err != nil {
+		return err
+	}
 
-	return err
+	h.log.Infof("Configuration response: %s", r.Message)
+
+	return nil
 }
 
-func (h *Host) fetchJWT(ctx context.Context) (err error) {
-	if h.rawJWT != "" {
-		h.log.Infof("Already have JWT for %s, not retrieving again", h.Identity)
+// validateConfigureRequest ensures nodes that must have TLS material are not configured without it, the
+// node only writes the certificate and CA when all of them are set and would otherwise start without them
+func (h *Host) validateConfigureRequest(creq *provision.ConfigureRequest) error {
+	if !h.cfg.RequireCertificate {
 		return nil
 	}
 
-	h.log.Info("Fetching JWT")
+	var missing []string
+
+	if creq.Certificate == "" {
+		missing = append(missing, "certificate")
+	}
 
-	jwtreq := &provision.JWTRequest{
-		Token: h.token,
+	if creq.CA == "" {
+		missing = append(missing, "CA")
 	}
 
+	if creq.SSLDir == "" {
+		missing = append(missing, "ssldir")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("refusing to configure %s without %s", h.Identity, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (h *Host) fetchJWT(ctx context.Context) (err error) {
+	if h.rawJWT != "" {
+		if !h.jwtNeedsRefresh() {
+			h.log.Infof("Already have JWT for %s, not retrieving again", h.Identity)
+			return nil
+		}
+
+		h.log.Infof("JWT for %s expires within %s, retrieving again", h.Identity, h.cfg.JWTRefreshWindowDuration)
+		jwtRefreshCtr.WithLabelValues(h.cfg.Site).Inc()
+		h.rawJWT = ""
+		h.JWT = nil
+	}
+
+	h.log.Info("Fetching JWT")
+
 	for try := 1; try <= 5; try++ {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
-		_, err = h.rpcDo(ctx, "choria_provision", "jwt", jwtreq, func(pr protocol.Reply, reply *rpc.RPCReply) {
-			resp := &provision.JWTReply{}
-			err := json.Unmarshal(reply.Data, resp)
-			if err != nil {
-				h.log.Errorf("Invalid JSON data: %s", err)
-				return
-			}
-
-			h.rawJWT = resp.JWT
-		})
+		var resp *provision.JWTReply
+		resp, err = h.client().JWT(ctx, &provision.JWTRequest{})
 		if err == nil {
-			if len(h.rawJWT) == 0 {
+			if len(resp.JWT) == 0 {
 				return fmt.Errorf("received an empty JWT")
 			}
 
+			h.rawJWT = resp.JWT
+
 			return nil
 		}
 	}
@@ -176,13 +316,20 @@ func (h *Host) fetchInventory(ctx context.Context) (err error) {
 		}
 
 		if try > 1 {
-			h.log.Warnf("Could not fetch rpcutil#inventory from %s on try %d / 5, retrying", h.Identity, try-1)
+			h.log.Warnf("Could not fetch rpcutil#inventory from %s on try %d / 5: %s, retrying", h.Identity, try-1, err)
 		}
 
-		_, err = h.rpcDo(ctx, "rpcutil", "inventory", struct{}{}, func(pr protocol.Reply, reply *rpc.RPCReply) {
-			h.Metadata = string(reply.Data)
+		var inventory string
+		err = h.rpcRequester().Request(ctx, "rpcutil", "inventory", struct{}{}, func(pr protocol.Reply, reply *rpc.RPCReply) {
+			inventory = string(reply.Data)
 		})
+		if err != nil {
+			continue
+		}
+
+		err = h.validateInventory(inventory)
 		if err == nil {
+			h.Metadata = inventory
 			return nil
 		}
 	}
@@ -190,22 +337,45 @@ func (h *Host) fetchInventory(ctx context.Context) (err error) {
 	return err
 }
 
+func (h *Host) validateInventory(inventory string) error {
+	if inventory == "" {
+		return fmt.Errorf("empty inventory received from %s", h.Identity)
+	}
+
+	inv := struct {
+		Agents []string               `json:"agents"`
+		Facts  map[string]interface{} `json:"facts"`
+	}{}
+
+	err := json.Unmarshal([]byte(inventory), &inv)
+	if err != nil {
+		return fmt.Errorf("invalid inventory received from %s: %s", h.Identity, err)
+	}
+
+	if len(inv.Agents) == 0 {
+		return fmt.Errorf("invalid inventory received from %s: no agents listed", h.Identity)
+	}
+
+	if inv.Facts == nil {
+		return fmt.Errorf("invalid inventory received from %s: no facts", h.Identity)
+	}
+
+	if h.cfg.RequireFacts && len(inv.Facts) == 0 {
+		return fmt.Errorf("invalid inventory received from %s: facts are empty", h.Identity)
+	}
+
+	return nil
+}
+
 func (h *Host) fetchCSR(ctx context.Context) error {
 	h.log.Info("Fetching CSR")
 
-	csreq := &provision.CSRRequest{
-		Token: h.token,
-		CN:    h.Identity,
+	csr, err := h.client().GenCSR(ctx, h.csrRequest())
+	if err != nil {
+		return err
 	}
 
-	_, err := h.rpcDo(ctx, "choria_provision", "gencsr", csreq, func(pr protocol.Reply, reply *rpc.RPCReply) {
-		h.CSR = &provision.CSRReply{}
-		err := json.Unmarshal(reply.Data, h.CSR)
-		if err != nil {
-			h.log.Errorf("Could not parse reply from %s: %s", pr.SenderID(), err)
-			return
-		}
-	})
+	h.CSR = csr
 
-	return err
+	return nil
 }
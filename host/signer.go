@@ -0,0 +1,141 @@
+package host
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/choria-io/go-choria/providers/agent/mcorpc/golang/provision"
+)
+
+// Signer signs CSRs received from nodes using a CA
+type Signer struct {
+	cert     *x509.Certificate
+	certPEM  string
+	key      crypto.Signer
+	validity time.Duration
+}
+
+// NewSigner creates a signer using the PEM encoded CA certificate and key, signed certificates are valid for validity
+func NewSigner(certFile string, keyFile string, validity time.Duration) (*Signer, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA certificate: %s", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode CA certificate %s", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CA certificate: %s", err)
+	}
+
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", certFile)
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA key: %s", err)
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CA key: %s", err)
+	}
+
+	return &Signer{
+		cert:     cert,
+		certPEM:  string(certPEM),
+		key:      key,
+		validity: validity,
+	}, nil
+}
+
+// Sign signs the CSR returning the PEM encoded certificate and CA, the names requested in the
+// CSR are kept and the certificate is usable as both server and client certificate like Choria requires
+func (s *Signer) Sign(csr *provision.CSRReply) (cert string, ca string, err error) {
+	if csr == nil || csr.CSR == "" {
+		return "", "", fmt.Errorf("no CSR received")
+	}
+
+	block, _ := pem.Decode([]byte(csr.CSR))
+	if block == nil {
+		return "", "", fmt.Errorf("could not decode CSR")
+	}
+
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse CSR: %s", err)
+	}
+
+	err = req.CheckSignature()
+	if err != nil {
+		return "", "", fmt.Errorf("invalid CSR signature: %s", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", fmt.Errorf("could not generate serial: %s", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        req.Subject,
+		DNSNames:       req.DNSNames,
+		IPAddresses:    req.IPAddresses,
+		EmailAddresses: req.EmailAddresses,
+		URIs:           req.URIs,
+		NotBefore:      now.Add(-5 * time.Minute),
+		NotAfter:       now.Add(s.validity),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.cert, req.PublicKey, s.key)
+	if err != nil {
+		return "", "", fmt.Errorf("could not sign CSR: %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), s.certPEM, nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+
+		return signer, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", block.Type)
+	}
+}
@@ -22,6 +22,56 @@ var (
 		Name: "choria_provisioner_helper_errors",
 		Help: "How many helper related errors were encountered",
 	}, []string{"site"})
+
+	timeoutCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_provision_timeouts",
+		Help: "How many hosts failed to provision within the provision timeout",
+	}, []string{"site"})
+
+	webhookErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_webhook_errors",
+		Help: "How many webhook notifications could not be delivered",
+	}, []string{"site"})
+
+	restartWaitDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name: "choria_provisioner_restart_wait_time",
+		Help: "How long restarts waited to be dispatched due to restart pacing",
+	}, []string{"site"})
+
+	deadLetterCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_dead_lettered",
+		Help: "How many hosts were dead lettered after failing to provision too many times",
+	}, []string{"site"})
+
+	verifyErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_restart_verify_errors",
+		Help: "How many nodes did not start outside of provisioning mode after being restarted",
+	}, []string{"site"})
+
+	abortRetryCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_abort_retries",
+		Help: "How many times requests aborted by nodes for a retryable reason were retried",
+	}, []string{"site", "action"})
+
+	jwtRefreshCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_jwt_refreshes",
+		Help: "How many JWTs were retrieved again because they expired or were about to expire",
+	}, []string{"site"})
+
+	duplicateCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_duplicate_identities",
+		Help: "How many requests received responses from more than one node with the same identity",
+	}, []string{"site", "identity"})
+
+	quarantinedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_quarantined_hosts",
+		Help: "How many hosts are quarantined after repeatedly failing verification",
+	}, []string{"site"})
+
+	reprovisionThrottledCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_reprovision_throttled",
+		Help: "How many reprovision requests were delayed due to reprovision pacing",
+	}, []string{"site"})
 )
 
 func init() {
@@ -29,4 +79,14 @@ func init() {
 	prometheus.MustRegister(helperDuration)
 	prometheus.MustRegister(rpcErrCtr)
 	prometheus.MustRegister(helperErrCtr)
+	prometheus.MustRegister(timeoutCtr)
+	prometheus.MustRegister(webhookErrCtr)
+	prometheus.MustRegister(restartWaitDuration)
+	prometheus.MustRegister(deadLetterCtr)
+	prometheus.MustRegister(verifyErrCtr)
+	prometheus.MustRegister(reprovisionThrottledCtr)
+	prometheus.MustRegister(abortRetryCtr)
+	prometheus.MustRegister(jwtRefreshCtr)
+	prometheus.MustRegister(duplicateCtr)
+	prometheus.MustRegister(quarantinedGauge)
 }
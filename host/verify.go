@@ -0,0 +1,73 @@
+package host
+
+import (
+	"context"
+	"fmt"
+)
+
+// VerifyError is returned when a node could not be verified to have started correctly after provisioning
+type VerifyError struct {
+	Identity string
+	Reason   string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s %s", e.Identity, e.Reason)
+}
+
+// StartupWatcher notifies about nodes publishing startup lifecycle events
+type StartupWatcher interface {
+	// Watch returns a channel that receives the lifecycle component identity next starts as
+	Watch(identity string) <-chan string
+
+	// Unwatch stops watching for identity
+	Unwatch(identity string)
+}
+
+// SetStartupWatcher enables verifying that the node started outside of provisioning mode after restarting it
+func (h *Host) SetStartupWatcher(w StartupWatcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.startup = w
+}
+
+func (h *Host) restartAndVerify(ctx context.Context) error {
+	if h.startup == nil || h.cfg.RestartVerifyTimeoutDuration <= 0 {
+		return h.restart(ctx)
+	}
+
+	// watch before restarting so a fast restart is not missed
+	started := h.startup.Watch(h.Identity)
+	defer h.startup.Unwatch(h.Identity)
+
+	err := h.restart(ctx)
+	if err != nil {
+		return err
+	}
+
+	return h.verifyRestart(ctx, started)
+}
+
+func (h *Host) verifyRestart(ctx context.Context, started <-chan string) error {
+	h.log.Infof("Waiting up to %s for the node to start", h.cfg.RestartVerifyTimeoutDuration)
+
+	tctx, cancel := context.WithTimeout(ctx, h.cfg.RestartVerifyTimeoutDuration)
+	defer cancel()
+
+	select {
+	case component := <-started:
+		if component == h.cfg.LifecycleComponent {
+			verifyErrCtr.WithLabelValues(h.cfg.Site).Inc()
+			return &VerifyError{Identity: h.Identity, Reason: "started in provisioning mode after restarting"}
+		}
+
+		h.log.Infof("Node started as %s after restarting", component)
+
+		return nil
+
+	case <-tctx.Done():
+		verifyErrCtr.WithLabelValues(h.cfg.Site).Inc()
+		return &VerifyError{Identity: h.Identity, Reason: fmt.Sprintf("did not start within %s after restarting", h.cfg.RestartVerifyTimeoutDuration)}
+	}
+}
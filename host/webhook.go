@@ -0,0 +1,97 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type webhookNotification struct {
+	Identity        string `json:"identity"`
+	Site            string `json:"site"`
+	Success         bool   `json:"success"`
+	Error           string `json:"error,omitempty"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+	Time            int64  `json:"time"`
+}
+
+// webhookRetryInterval is the delay between webhook delivery attempts
+var webhookRetryInterval = 2 * time.Second
+
+// postWebhook notifies the configured webhook of the provisioning outcome, failures are logged and never fail provisioning
+func (h *Host) postWebhook(ctx context.Context, perr error) {
+	if h.cfg.WebhookURL == "" {
+		return
+	}
+
+	result := h.result(perr)
+
+	notification := webhookNotification{
+		Identity:        result.Identity,
+		Site:            h.cfg.Site,
+		Success:         result.Error == nil,
+		CertFingerprint: result.CertFingerprint,
+		Time:            time.Now().Unix(),
+	}
+
+	if result.Error != nil {
+		notification.Error = result.Error.Error()
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		webhookErrCtr.WithLabelValues(h.cfg.Site).Inc()
+		h.log.Errorf("Could not encode webhook notification: %s", err)
+		return
+	}
+
+	for try := 1; try <= h.cfg.WebhookRetries; try++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if try > 1 {
+			h.log.Warnf("Could not deliver webhook notification on try %d / %d: %s, retrying", try-1, h.cfg.WebhookRetries, err)
+
+			select {
+			case <-time.After(webhookRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err = h.deliverWebhook(ctx, body)
+		if err == nil {
+			return
+		}
+	}
+
+	webhookErrCtr.WithLabelValues(h.cfg.Site).Inc()
+	h.log.Errorf("Could not deliver webhook notification to %s: %s", h.cfg.WebhookURL, err)
+}
+
+func (h *Host) deliverWebhook(ctx context.Context, body []byte) error {
+	tctx, cancel := context.WithTimeout(ctx, h.cfg.WebhookTimeoutDuration)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(tctx, http.MethodPost, h.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"sync"
 
-	"github.com/choria-io/go-choria/lifecycle"
-
 	"github.com/choria-io/provisioning-agent/host"
 
 	"github.com/choria-io/go-choria/choria"
@@ -23,42 +21,23 @@ func connect(ctx context.Context) (choria.Connector, error) {
 func listen(ctx context.Context, wg *sync.WaitGroup, component string, conn choria.Connector) {
 	defer wg.Done()
 
-	events := make(chan *choria.ConnectorMessage, 1000)
-
-	rid, err := fw.NewRequestID()
-	if err != nil {
-		log.Errorf("Could not create provisioning data listener unique id: %s", err)
-		return
-	}
-
-	err = conn.QueueSubscribe(ctx, rid, "choria.provisioning_data", "", events)
-	if err != nil {
-		log.Errorf("Could not listen for provisioning data events: %s", err)
-		return
-	}
+	found := make(chan *host.Host, 1000)
 
-	rid, err = fw.NewRequestID()
-	if err != nil {
-		log.Errorf("Could not create lifecycle event listener unique id: %s", err)
-		return
-	}
+	watcher := NewWatcher(fw, conf)
+	watcher.Component = component
 
-	err = conn.QueueSubscribe(ctx, rid, fmt.Sprintf("choria.lifecycle.event.startup.%s", component), "", events)
-	if err != nil {
-		log.Errorf("Could not listen for lifecycle events: %s", err)
-		return
-	}
+	go func() {
+		err := watcher.Watch(ctx, conn, found)
+		if err != nil {
+			log.Errorf("Could not watch for nodes to provision: %s", err)
+		}
+	}()
 
 	for {
 		select {
-		case e := <-events:
-			node, err := handle(e)
-			if err != nil {
-				log.Errorf("could not handle message: %s", err)
-			}
-
-			if node != "" && add(host.NewHost(node, conf)) {
-				log.Infof("Adding %s to the provision list after receiving an event", node)
+		case node := <-found:
+			if add(node) {
+				log.Infof("Adding %s to the provision list after receiving an event", node.Identity)
 				eventsCtr.WithLabelValues(conf.Site).Inc()
 			}
 
@@ -67,34 +46,3 @@ func listen(ctx context.Context, wg *sync.WaitGroup, component string, conn chor
 		}
 	}
 }
-
-func handle(msg *choria.ConnectorMessage) (string, error) {
-	if conf.Paused() {
-		log.Warnf("Skipping event processing while paused")
-		return "", nil
-	}
-
-	event, err := lifecycle.NewFromJSON(msg.Bytes())
-	if err == nil {
-		return handleEvent(event)
-	}
-
-	return handleRegistration(msg)
-}
-
-func handleEvent(event lifecycle.Event) (string, error) {
-	if event.Type() != lifecycle.Startup {
-		return "", nil
-	}
-
-	return event.Identity(), nil
-}
-
-func handleRegistration(msg *choria.ConnectorMessage) (string, error) {
-	t, err := fw.NewTransportFromJSON(string(msg.Data))
-	if err != nil {
-		return "", fmt.Errorf("could not create transport: %s", err)
-	}
-
-	return t.SenderID(), nil
-}
@@ -19,14 +19,17 @@ import (
 )
 
 var (
-	hosts = make(map[string]*host.Host)
-	work  = make(chan *host.Host, 1000)
-	done  = make(chan *host.Host, 1000)
-	mu    = &sync.Mutex{}
-	log   *logrus.Entry
-	fw    *choria.Framework
-	conf  *config.Config
-	wg    = &sync.WaitGroup{}
+	hosts      = make(map[string]*host.Host)
+	work       = make(chan *host.Host, 1000)
+	done       = make(chan *host.Host, 1000)
+	mu         = &sync.Mutex{}
+	log        *logrus.Entry
+	fw         *choria.Framework
+	conf       *config.Config
+	wg         = &sync.WaitGroup{}
+	attempts   *host.AttemptTracker
+	quarantine *host.Quarantine
+	startups   *startupWatcher
 )
 
 // Process starts the provisioning process
@@ -34,6 +37,8 @@ func Process(ctx context.Context, cfg *config.Config, cfw *choria.Framework) err
 	fw = cfw
 	conf = cfg
 	log = fw.Logger("hosts")
+	attempts = host.NewAttemptTracker(conf.MaxAttempts, conf.Site)
+	quarantine = host.NewQuarantine(conf.QuarantineAfter, conf.QuarantineCooldownDuration, conf.Site)
 
 	log.Infof("Choria Provisioner starting using configuration file %s. Discovery interval %s using %d workers", conf.File, conf.Interval, conf.Workers)
 
@@ -63,6 +68,12 @@ func Process(ctx context.Context, cfg *config.Config, cfw *choria.Framework) err
 	wg.Add(1)
 	go finisher(ctx, wg)
 
+	if conf.RestartVerifyTimeoutDuration > 0 {
+		startups = newStartupWatcher()
+		wg.Add(1)
+		go startups.listen(ctx, wg, conn)
+	}
+
 	if conf.Management != nil {
 		wg.Add(1)
 		go startBackplane(ctx, wg)
@@ -127,9 +138,23 @@ func add(host *host.Host) bool {
 		return false
 	}
 
+	if _, dead := attempts.DeadLettered(host.Identity); dead {
+		log.Debugf("Not adding dead lettered host %s to the work queue", host.Identity)
+		return false
+	}
+
+	if _, quarantined := quarantine.Quarantined(host.Identity); quarantined {
+		log.Debugf("Not adding quarantined host %s to the work queue", host.Identity)
+		return false
+	}
+
 	log.Debugf("Adding %s to the work queue with %d entries", host.Identity, len(hosts))
 	hosts[host.Identity] = host
 
+	if startups != nil {
+		host.SetStartupWatcher(startups)
+	}
+
 	work <- host
 
 	return true
@@ -43,9 +43,17 @@ func provisionTarget(ctx context.Context, target *host.Host) error {
 
 	err := target.Provision(ctx, fw)
 	if err != nil {
+		if attempts.Failed(target.Identity, err) {
+			log.Errorf("Not attempting to provision %s again after %d failures", target.Identity, conf.MaxAttempts)
+		} else if quarantine.Failed(target.Identity, err) {
+			log.Errorf("Quarantined %s after failing verification %d times", target.Identity, conf.QuarantineAfter)
+		}
+
 		return err
 	}
 
+	attempts.Succeeded(target.Identity)
+	quarantine.Succeeded(target.Identity)
 	provisionedCtr.WithLabelValues(conf.Site).Inc()
 
 	return nil
@@ -0,0 +1,92 @@
+package hosts
+
+import (
+	"context"
+	"sync"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/lifecycle"
+)
+
+// startupWatcher dispatches startup lifecycle events to hosts waiting for their nodes to restart
+type startupWatcher struct {
+	waiting map[string]chan string
+	mu      *sync.Mutex
+}
+
+func newStartupWatcher() *startupWatcher {
+	return &startupWatcher{
+		waiting: make(map[string]chan string),
+		mu:      &sync.Mutex{},
+	}
+}
+
+// Watch implements host.StartupWatcher
+func (s *startupWatcher) Watch(identity string) <-chan string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan string, 1)
+	s.waiting[identity] = ch
+
+	return ch
+}
+
+// Unwatch implements host.StartupWatcher
+func (s *startupWatcher) Unwatch(identity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.waiting, identity)
+}
+
+func (s *startupWatcher) started(identity string, component string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.waiting[identity]
+	if !ok {
+		return
+	}
+
+	select {
+	case ch <- component:
+	default:
+	}
+}
+
+func (s *startupWatcher) listen(ctx context.Context, wg *sync.WaitGroup, conn choria.Connector) {
+	defer wg.Done()
+
+	events := make(chan *choria.ConnectorMessage, 1000)
+
+	rid, err := fw.NewRequestID()
+	if err != nil {
+		log.Errorf("Could not create startup event listener unique id: %s", err)
+		return
+	}
+
+	err = conn.QueueSubscribe(ctx, rid, "choria.lifecycle.event.startup.>", "", events)
+	if err != nil {
+		log.Errorf("Could not listen for startup events: %s", err)
+		return
+	}
+
+	for {
+		select {
+		case e := <-events:
+			event, err := lifecycle.NewFromJSON(e.Bytes())
+			if err != nil {
+				log.Debugf("Could not parse startup event: %s", err)
+				continue
+			}
+
+			if event.Type() == lifecycle.Startup {
+				s.started(event.Identity(), event.Component())
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/lifecycle"
+	"github.com/choria-io/provisioning-agent/config"
+	"github.com/choria-io/provisioning-agent/host"
+	"github.com/sirupsen/logrus"
+)
+
+// EventFilter decides if a node should be provisioned based on a lifecycle event it published
+type EventFilter func(event lifecycle.Event) bool
+
+// Watcher listens in the provisioning collective for nodes announcing they entered provisioning mode
+type Watcher struct {
+	// Component is the lifecycle component that nodes in provisioning mode publish startup events as
+	Component string
+
+	// Filter selects the lifecycle events that result in hosts, all startup events are accepted when nil
+	Filter EventFilter
+
+	// Timeout stops watching after this long, 0 watches until the context is cancelled
+	Timeout time.Duration
+
+	fw   *choria.Framework
+	conf *config.Config
+	log  *logrus.Entry
+}
+
+// NewWatcher creates a watcher for nodes publishing startup events as the configured lifecycle component
+func NewWatcher(fw *choria.Framework, conf *config.Config) *Watcher {
+	return &Watcher{
+		Component: conf.LifecycleComponent,
+		fw:        fw,
+		conf:      conf,
+		log:       fw.Logger("watcher"),
+	}
+}
+
+// Watch subscribes to provisioning data and lifecycle events using conn and publishes new hosts to found
+func (w *Watcher) Watch(ctx context.Context, conn choria.Connector, found chan<- *host.Host) error {
+	events := make(chan *choria.ConnectorMessage, 1000)
+
+	rid, err := w.fw.NewRequestID()
+	if err != nil {
+		return fmt.Errorf("could not create provisioning data listener unique id: %s", err)
+	}
+
+	err = conn.QueueSubscribe(ctx, rid, "choria.provisioning_data", "", events)
+	if err != nil {
+		return fmt.Errorf("could not listen for provisioning data events: %s", err)
+	}
+
+	rid, err = w.fw.NewRequestID()
+	if err != nil {
+		return fmt.Errorf("could not create lifecycle event listener unique id: %s", err)
+	}
+
+	err = conn.QueueSubscribe(ctx, rid, fmt.Sprintf("choria.lifecycle.event.startup.%s", w.Component), "", events)
+	if err != nil {
+		return fmt.Errorf("could not listen for lifecycle events: %s", err)
+	}
+
+	w.process(ctx, events, found)
+
+	return nil
+}
+
+func (w *Watcher) process(ctx context.Context, events chan *choria.ConnectorMessage, found chan<- *host.Host) {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case e := <-events:
+			node, err := w.handle(e)
+			if err != nil {
+				w.log.Errorf("could not handle message: %s", err)
+			}
+
+			if node == "" {
+				continue
+			}
+
+			select {
+			case found <- host.NewHost(node, w.conf):
+			case <-ctx.Done():
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Watcher) handle(msg *choria.ConnectorMessage) (string, error) {
+	if w.conf.Paused() {
+		w.log.Warnf("Skipping event processing while paused")
+		return "", nil
+	}
+
+	event, err := lifecycle.NewFromJSON(msg.Bytes())
+	if err == nil {
+		return w.handleEvent(event)
+	}
+
+	return w.handleRegistration(msg)
+}
+
+func (w *Watcher) handleEvent(event lifecycle.Event) (string, error) {
+	if event.Type() != lifecycle.Startup {
+		return "", nil
+	}
+
+	if w.Filter != nil && !w.Filter(event) {
+		return "", nil
+	}
+
+	return event.Identity(), nil
+}
+
+func (w *Watcher) handleRegistration(msg *choria.ConnectorMessage) (string, error) {
+	t, err := w.fw.NewTransportFromJSON(string(msg.Data))
+	if err != nil {
+		return "", fmt.Errorf("could not create transport: %s", err)
+	}
+
+	return t.SenderID(), nil
+}
@@ -0,0 +1,113 @@
+package hosts
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/lifecycle"
+	"github.com/choria-io/provisioning-agent/config"
+	"github.com/choria-io/provisioning-agent/host"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHosts(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Hosts")
+}
+
+var _ = Describe("Watcher", func() {
+	var (
+		w      *Watcher
+		events chan *choria.ConnectorMessage
+		found  chan *host.Host
+	)
+
+	event := func(t lifecycle.Type, identity string, component string) *choria.ConnectorMessage {
+		e, err := lifecycle.New(t, lifecycle.Identity(identity), lifecycle.Component(component), lifecycle.Version("1.0.0"))
+		Expect(err).ToNot(HaveOccurred())
+
+		j, err := json.Marshal(e)
+		Expect(err).ToNot(HaveOccurred())
+
+		return &choria.ConnectorMessage{Data: j}
+	}
+
+	BeforeEach(func() {
+		log := logrus.NewEntry(logrus.New())
+		log.Logger.Out = ioutil.Discard
+
+		events = make(chan *choria.ConnectorMessage, 10)
+		found = make(chan *host.Host, 10)
+		w = &Watcher{
+			Component: "provision_mode_server",
+			Timeout:   100 * time.Millisecond,
+			conf:      &config.Config{},
+			log:       log,
+		}
+	})
+
+	It("Should publish hosts for startup events", func() {
+		events <- event(lifecycle.Startup, "node1.example.net", "provision_mode_server")
+		events <- event(lifecycle.Shutdown, "node2.example.net", "provision_mode_server")
+		events <- event(lifecycle.Startup, "node3.example.net", "provision_mode_server")
+
+		w.process(context.Background(), events, found)
+
+		Expect(found).To(HaveLen(2))
+		Expect((<-found).Identity).To(Equal("node1.example.net"))
+		Expect((<-found).Identity).To(Equal("node3.example.net"))
+	})
+
+	It("Should apply the event filter", func() {
+		w.Filter = func(e lifecycle.Event) bool {
+			return e.Identity() != "node1.example.net"
+		}
+
+		events <- event(lifecycle.Startup, "node1.example.net", "provision_mode_server")
+		events <- event(lifecycle.Startup, "node2.example.net", "provision_mode_server")
+
+		w.process(context.Background(), events, found)
+
+		Expect(found).To(HaveLen(1))
+		Expect((<-found).Identity).To(Equal("node2.example.net"))
+	})
+
+	It("Should not publish hosts while paused", func() {
+		w.conf.Pause()
+		events <- event(lifecycle.Startup, "node1.example.net", "provision_mode_server")
+
+		w.process(context.Background(), events, found)
+
+		Expect(found).To(BeEmpty())
+	})
+
+	It("Should stop after the timeout", func() {
+		start := time.Now()
+		w.process(context.Background(), events, found)
+		Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+	})
+})
+
+var _ = Describe("startupWatcher", func() {
+	It("Should notify watched hosts only", func() {
+		s := newStartupWatcher()
+		started := s.Watch("node1.example.net")
+
+		s.started("node2.example.net", "server")
+		Expect(started).To(BeEmpty())
+
+		s.started("node1.example.net", "server")
+		Expect(started).To(Receive(Equal("server")))
+
+		s.Unwatch("node1.example.net")
+		s.started("node1.example.net", "server")
+		Expect(started).To(BeEmpty())
+	})
+})